@@ -0,0 +1,281 @@
+package gocask_test
+
+import (
+	"errors"
+	"io"
+	"iter"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sebapastore/gocask"
+	"github.com/stretchr/testify/require"
+)
+
+// The exported API with the signatures it promises. Changing any of them
+// breaks callers, so it stops this file from compiling.
+var (
+	_ func(string, ...gocask.Option) (*gocask.DB, error) = gocask.Open
+	_ func(string) (int, error)                          = gocask.Migrate
+	_ func() gocask.Options                              = gocask.DefaultOptions
+
+	_ func(gocask.Options) gocask.Option                               = gocask.WithOptions
+	_ func(uint64) gocask.Option                                       = gocask.WithMaxFileSize
+	_ func(uint64, uint64) gocask.Option                               = gocask.WithSizeLimits
+	_ func(uint64) gocask.Option                                       = gocask.WithBlobThreshold
+	_ func() gocask.Option                                             = gocask.WithReadOnly
+	_ func(gocask.SyncPolicy, time.Duration) gocask.Option             = gocask.WithSyncPolicy
+	_ func() gocask.Option                                             = gocask.WithGroupCommit
+	_ func(gocask.CorruptionPolicy) gocask.Option                      = gocask.WithCorruptionPolicy
+	_ func(time.Duration) gocask.Option                                = gocask.WithSweepInterval
+	_ func(int, time.Duration) gocask.Option                           = gocask.WithHistoryRetention
+	_ func(os.FileMode) gocask.Option                                  = gocask.WithFileMode
+	_ func(time.Duration, int) gocask.Option                           = gocask.WithMergeTrigger
+	_ func(*slog.Logger) gocask.Option                                 = gocask.WithLogger
+	_ func(gocask.Metrics) gocask.Option                               = gocask.WithMetrics
+	_ func(gocask.Options) error                                       = gocask.Options.Validate
+	_ func(*gocask.DB) error                                           = (*gocask.DB).Close
+	_ func(*gocask.DB, string) (string, bool, error)                   = (*gocask.DB).Get
+	_ func(*gocask.DB, []byte) ([]byte, bool, error)                   = (*gocask.DB).GetBytes
+	_ func(*gocask.DB, []byte) (io.ReadCloser, error)                  = (*gocask.DB).GetReader
+	_ func(*gocask.DB, string, string) error                           = (*gocask.DB).Set
+	_ func(*gocask.DB, []byte, []byte) error                           = (*gocask.DB).Put
+	_ func(*gocask.DB, []byte, io.Reader, int64) error                 = (*gocask.DB).PutReader
+	_ func(*gocask.DB, string) error                                   = (*gocask.DB).Delete
+	_ func(*gocask.DB, string, string, time.Duration) error            = (*gocask.DB).SetWithTTL
+	_ func(*gocask.DB, []byte, []byte, time.Duration) error            = (*gocask.DB).PutWithTTL
+	_ func(*gocask.DB, string) (time.Duration, bool, error)            = (*gocask.DB).TTL
+	_ func(*gocask.DB, string, string, string) (bool, error)           = (*gocask.DB).CompareAndSwap
+	_ func(*gocask.DB, string, string) (bool, error)                   = (*gocask.DB).SetIfAbsent
+	_ func(*gocask.DB, string, string) (bool, error)                   = (*gocask.DB).DeleteIfEquals
+	_ func(*gocask.DB, *gocask.Batch) error                            = (*gocask.DB).Write
+	_ func(*gocask.DB, func(*gocask.Txn) error) error                  = (*gocask.DB).View
+	_ func(*gocask.DB, func(*gocask.Txn) error) error                  = (*gocask.DB).Update
+	_ func(*gocask.DB) (*gocask.Snapshot, error)                       = (*gocask.DB).Snapshot
+	_ func(*gocask.DB, string) ([]gocask.Version, error)               = (*gocask.DB).History
+	_ func(*gocask.DB, string, time.Time) (string, bool, error)        = (*gocask.DB).GetAt
+	_ func(*gocask.DB, []byte, time.Time) ([]byte, bool, error)        = (*gocask.DB).GetBytesAt
+	_ func(*gocask.DB) iter.Seq2[[]byte, []byte]                       = (*gocask.DB).All
+	_ func(*gocask.DB) iter.Seq[[]byte]                                = (*gocask.DB).Keys
+	_ func(*gocask.DB, string, string) iter.Seq2[[]byte, []byte]       = (*gocask.DB).Scan
+	_ func(*gocask.DB, string) iter.Seq2[[]byte, []byte]               = (*gocask.DB).Prefix
+	_ func(*gocask.DB, string, string) *gocask.Iterator                = (*gocask.DB).Iterator
+	_ func(*gocask.DB, string) *gocask.Iterator                        = (*gocask.DB).PrefixIterator
+	_ func(*gocask.DB) error                                           = (*gocask.DB).Merge
+	_ func(*gocask.DB) error                                           = (*gocask.DB).Sync
+	_ func(*gocask.DB) uint64                                          = (*gocask.DB).DiscardedBytes
+	_ func(*gocask.Batch, []byte, []byte)                              = (*gocask.Batch).Put
+	_ func(*gocask.Batch, []byte)                                      = (*gocask.Batch).Delete
+	_ func(*gocask.Batch) int                                          = (*gocask.Batch).Len
+	_ func(*gocask.Txn, string) (string, bool, error)                  = (*gocask.Txn).Get
+	_ func(*gocask.Txn, []byte) ([]byte, bool, error)                  = (*gocask.Txn).GetBytes
+	_ func(*gocask.Txn, string, string) error                          = (*gocask.Txn).Set
+	_ func(*gocask.Txn, []byte, []byte) error                          = (*gocask.Txn).Put
+	_ func(*gocask.Txn, []byte) error                                  = (*gocask.Txn).Delete
+	_ func(*gocask.Snapshot, string) (string, bool, error)             = (*gocask.Snapshot).Get
+	_ func(*gocask.Snapshot, []byte) ([]byte, bool, error)             = (*gocask.Snapshot).GetBytes
+	_ func(*gocask.Snapshot) iter.Seq2[[]byte, []byte]                 = (*gocask.Snapshot).All
+	_ func(*gocask.Snapshot) iter.Seq[[]byte]                          = (*gocask.Snapshot).Keys
+	_ func(*gocask.Snapshot, string, string) iter.Seq2[[]byte, []byte] = (*gocask.Snapshot).Scan
+	_ func(*gocask.Snapshot, string) iter.Seq2[[]byte, []byte]         = (*gocask.Snapshot).Prefix
+	_ func(*gocask.Snapshot, string, string) *gocask.Iterator          = (*gocask.Snapshot).Iterator
+	_ func(*gocask.Snapshot, string) *gocask.Iterator                  = (*gocask.Snapshot).PrefixIterator
+	_ func(*gocask.Snapshot)                                           = (*gocask.Snapshot).Release
+	_ func(*gocask.Iterator) bool                                      = (*gocask.Iterator).Next
+	_ func(*gocask.Iterator) string                                    = (*gocask.Iterator).Key
+	_ func(*gocask.Iterator) ([]byte, error)                           = (*gocask.Iterator).Value
+	_ func(*gocask.Iterator) iter.Seq2[string, []byte]                 = (*gocask.Iterator).All
+	_ func(*gocask.Iterator) iter.Seq[string]                          = (*gocask.Iterator).Keys
+	_ func(*gocask.Iterator) error                                     = (*gocask.Iterator).Err
+	_ func(*gocask.Iterator)                                           = (*gocask.Iterator).Close
+	_ error                                                            = &gocask.CorruptionError{}
+	_ interface{ Unwrap() error }                                      = &gocask.CorruptionError{}
+	_ []error                                                          = []error{gocask.ErrNotFound, gocask.ErrClosed, gocask.ErrEmptyKey, gocask.ErrKeyTooLarge, gocask.ErrValueTooLarge, gocask.ErrChecksumMismatch, gocask.ErrDatabaseLocked, gocask.ErrReadOnly, gocask.ErrUnsupportedVersion, gocask.ErrConflict, gocask.ErrTxnClosed, gocask.ErrSnapshotReleased, gocask.ErrInvalidOptions}
+	_ []gocask.SyncPolicy                                              = []gocask.SyncPolicy{gocask.SyncNever, gocask.SyncAlways, gocask.SyncInterval}
+	_ []gocask.CorruptionPolicy                                        = []gocask.CorruptionPolicy{gocask.CorruptionSkip, gocask.CorruptionFail, gocask.CorruptionQuarantine}
+)
+
+func TestOpenSetGet(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gocask.Open(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Set("name", "Sirius"))
+	require.NoError(t, db.Close())
+
+	db, err = gocask.Open(dir)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	val, ok, err := db.Get("name")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "Sirius", val)
+}
+
+func TestOpenAppliesOptions(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gocask.Open(dir, gocask.WithMaxFileSize(64), gocask.WithSyncPolicy(gocask.SyncAlways, 0))
+	require.NoError(t, err)
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Close())
+
+	// A second writer is locked out
+	writer, err := gocask.Open(dir)
+	require.NoError(t, err)
+	defer func() { _ = writer.Close() }()
+
+	_, err = gocask.Open(dir)
+	require.ErrorIs(t, err, gocask.ErrDatabaseLocked)
+
+	require.NoError(t, writer.Close())
+	reader, err := gocask.Open(dir, gocask.WithOptions(gocask.Options{ReadOnly: true}))
+	require.NoError(t, err)
+	defer func() { _ = reader.Close() }()
+
+	require.ErrorIs(t, reader.Set("key1", "value2"), gocask.ErrReadOnly)
+}
+
+func TestOpenValidatesOptions(t *testing.T) {
+	for name, opt := range map[string]gocask.Option{
+		"max key size":      gocask.WithSizeLimits(gocask.MaxKeyLength+1, 0),
+		"blob threshold":    gocask.WithBlobThreshold(gocask.MaxValueLength + 1),
+		"sync policy":       gocask.WithSyncPolicy(gocask.SyncPolicy(7), 0),
+		"sync interval":     gocask.WithSyncPolicy(gocask.SyncInterval, -time.Second),
+		"corruption policy": gocask.WithCorruptionPolicy(gocask.CorruptionPolicy(-1)),
+		"history versions":  gocask.WithHistoryRetention(-1, 0),
+		"file mode":         gocask.WithFileMode(0444),
+		"file mode type":    gocask.WithFileMode(os.ModeDir | 0755),
+		"merge min files":   gocask.WithMergeTrigger(time.Minute, -1),
+		"read-only merges":  func(o *gocask.Options) { *o = gocask.Options{ReadOnly: true, MergeInterval: time.Minute} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, err := gocask.Open(dir, opt)
+			require.ErrorIs(t, err, gocask.ErrInvalidOptions)
+
+			// Nothing was created
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			require.Empty(t, entries)
+		})
+	}
+
+	require.NoError(t, gocask.DefaultOptions().Validate())
+}
+
+func TestErrorsThroughPublicAPI(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gocask.Open(dir, gocask.WithSizeLimits(8, 8))
+	require.NoError(t, err)
+
+	require.ErrorIs(t, db.Set("", "value1"), gocask.ErrEmptyKey)
+	require.ErrorIs(t, db.Set("key1-too-long", "value1"), gocask.ErrKeyTooLarge)
+	require.ErrorIs(t, db.Set("key1", "value1-too-long"), gocask.ErrValueTooLarge)
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Set("key2", "value2"))
+	require.NoError(t, db.Close())
+
+	_, _, err = db.Get("key1")
+	require.ErrorIs(t, err, gocask.ErrClosed)
+	require.ErrorIs(t, db.Set("key1", "value2"), gocask.ErrClosed)
+
+	// Flip the last byte of the value of key1, whose 30 byte record follows
+	// the 16 byte file header
+	path := filepath.Join(dir, "data.1.cask")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[16+29] ^= 0xAA
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	_, err = gocask.Open(dir, gocask.WithCorruptionPolicy(gocask.CorruptionFail))
+	var corruptionErr *gocask.CorruptionError
+	require.ErrorAs(t, err, &corruptionErr)
+	require.Equal(t, uint64(1), corruptionErr.FileID)
+	require.Equal(t, uint64(16), corruptionErr.Offset)
+	require.ErrorIs(t, err, gocask.ErrChecksumMismatch)
+}
+
+func TestLargeValuesThroughPublicAPI(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gocask.Open(dir, gocask.WithBlobThreshold(64))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	value := strings.Repeat("v", 1000)
+	require.NoError(t, db.PutReader([]byte("key1"), strings.NewReader(value), int64(len(value))))
+
+	r, err := db.GetReader([]byte("key1"))
+	require.NoError(t, err)
+	read, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, value, string(read))
+
+	_, err = db.GetReader([]byte("missing"))
+	require.ErrorIs(t, err, gocask.ErrNotFound)
+
+	// The record only holds a reference to the blob file
+	info, err := os.Stat(filepath.Join(dir, "data.1.cask"))
+	require.NoError(t, err)
+	require.Less(t, info.Size(), int64(len(value)))
+}
+
+func TestTransactionsThroughPublicAPI(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gocask.Open(dir)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	var batch gocask.Batch
+	batch.Put([]byte("key1"), []byte("value1"))
+	batch.Put([]byte("key2"), []byte("value2"))
+	require.NoError(t, db.Write(&batch))
+
+	snap, err := db.Snapshot()
+	require.NoError(t, err)
+	defer snap.Release()
+
+	err = db.Update(func(tx *gocask.Txn) error {
+		val, _, err := tx.Get("key1")
+		if err != nil {
+			return err
+		}
+		require.NoError(t, db.Set("key1", "changed"))
+		return tx.Set("key2", val)
+	})
+	require.True(t, errors.Is(err, gocask.ErrConflict))
+
+	var keys []string
+	for key, value := range snap.All() {
+		keys = append(keys, string(key)+"="+string(value))
+	}
+	require.Equal(t, []string{"key1=value1", "key2=value2"}, keys)
+}
+
+func TestRangeThroughPublicAPI(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gocask.Open(dir)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("order:1", "value1"))
+	require.NoError(t, db.Set("user:1", "value2"))
+
+	var pairs []string
+	for key, value := range db.Prefix("user:") {
+		pairs = append(pairs, string(key)+"="+string(value))
+	}
+	require.Equal(t, []string{"user:1=value2"}, pairs)
+
+	count := 0
+	it := db.Iterator("", "")
+	for range it.All() {
+		count++
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, 2, count)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sebapastore/gocask"
+)
+
+// errExit is returned by runCommand for exit and quit, so run returns and the
+// database is closed.
+var errExit = errors.New("exit")
+
+func run(args []string, input io.Reader, output io.Writer) error {
+	var configPath string
+	var given []flagSetting
+	flags := flag.NewFlagSet("gocask", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&configPath, "config", "", "Config file, TOML or JSON")
+	for _, s := range settings {
+		flags.Var(flagValue{setting: s, given: &given}, s.flagName(), s.usage)
+	}
+
+	// Parse flags first
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	flags.Usage = func() { printUsage(output) }
+
+	cfg, err := loadConfig(configPath, os.LookupEnv, given)
+	if err != nil {
+		return err
+	}
+
+	// Remaining args after flags
+	remaining := flags.Args()
+
+	// Migrate works on the files directly and needs the database closed
+	if len(remaining) > 0 && remaining[0] == "migrate" {
+		return runMigrate(cfg.dbPath, remaining, output)
+	}
+
+	// Open DB
+	opts := cfg.opts
+	opts.Logger = cfg.logger(os.Stderr)
+	db, err := gocask.Open(cfg.dbPath, gocask.WithOptions(opts))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	if len(remaining) > 0 {
+		// Run a single command and exit
+		if err := runCommand(db, remaining, output); !errors.Is(err, errExit) {
+			return err
+		}
+		return nil
+	}
+
+	// No command: start interactive REPL
+	scanner := bufio.NewScanner(input)
+	_, _ = fmt.Fprintln(output, "gocask service ready. Enter commands:")
+
+	for {
+		_, _ = fmt.Fprint(output, "> ")
+		if !scanner.Scan() {
+			break // EOF or error
+		}
+
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+
+		err := runCommand(db, parts, output)
+		if errors.Is(err, errExit) {
+			return nil
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(output, "Error: %v\n", err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func runCommand(db *gocask.DB, args []string, output io.Writer) error {
+	command := args[0]
+
+	switch command {
+	case "set":
+		if len(args) != 3 && (len(args) != 5 || args[3] != "ex") {
+			_, _ = fmt.Fprintln(output, "Usage: set <key> <value> [ex <seconds>]")
+			return nil
+		}
+		key, value := args[1], args[2]
+		if len(args) == 5 {
+			seconds, err := strconv.ParseUint(args[4], 10, 32)
+			if err != nil || seconds == 0 {
+				_, _ = fmt.Fprintln(output, "Usage: set <key> <value> [ex <seconds>]")
+				return nil
+			}
+			if err := db.SetWithTTL(key, value, time.Duration(seconds)*time.Second); err != nil {
+				return fmt.Errorf("failed to set value: %w", err)
+			}
+			_, _ = fmt.Fprintf(output, "SET key=%s value=%s ex=%ds\n", key, value, seconds)
+			return nil
+		}
+		if err := db.Set(key, value); err != nil {
+			return fmt.Errorf("failed to set value: %w", err)
+		}
+		_, _ = fmt.Fprintf(output, "SET key=%s value=%s\n", key, value)
+
+	case "get":
+		if len(args) != 2 {
+			_, _ = fmt.Fprintln(output, "Usage: get <key>")
+			return nil
+		}
+		key := args[1]
+		value, exists, err := db.Get(key)
+		if err != nil {
+			return fmt.Errorf("failed to get value: %w", err)
+		}
+		if !exists {
+			_, _ = fmt.Fprintf(output, "No value for key %q\n", key)
+			return nil
+		}
+		_, _ = fmt.Fprintf(output, "Value for key %q is %q\n", key, value)
+
+	case "ttl":
+		if len(args) != 2 {
+			_, _ = fmt.Fprintln(output, "Usage: ttl <key>")
+			return nil
+		}
+		key := args[1]
+		ttl, exists, err := db.TTL(key)
+		if err != nil {
+			return fmt.Errorf("failed to get ttl: %w", err)
+		}
+		switch {
+		case !exists:
+			_, _ = fmt.Fprintf(output, "No value for key %q\n", key)
+		case ttl == 0:
+			_, _ = fmt.Fprintf(output, "Key %q does not expire\n", key)
+		default:
+			_, _ = fmt.Fprintf(output, "Key %q expires in %ds\n", key, int64(ttl.Round(time.Second)/time.Second))
+		}
+
+	case "history":
+		if len(args) != 2 {
+			_, _ = fmt.Fprintln(output, "Usage: history <key>")
+			return nil
+		}
+		key := args[1]
+		versions, err := db.History(key)
+		if err != nil {
+			return fmt.Errorf("failed to get history: %w", err)
+		}
+		if len(versions) == 0 {
+			_, _ = fmt.Fprintf(output, "No history for key %q\n", key)
+			return nil
+		}
+		for _, v := range versions {
+			at := v.Timestamp.UTC().Format(time.RFC3339Nano)
+			if v.Deleted {
+				_, _ = fmt.Fprintf(output, "%s deleted\n", at)
+			} else {
+				_, _ = fmt.Fprintf(output, "%s %q\n", at, v.Value)
+			}
+		}
+
+	case "del":
+		if len(args) != 2 {
+			_, _ = fmt.Fprintln(output, "Usage: del <key>")
+			return nil
+		}
+		key := args[1]
+		err := db.Delete(key)
+		if err != nil {
+			return fmt.Errorf("failed to delete key %q: %w", key, err)
+		}
+		_, _ = fmt.Fprintf(output, "Key %q was deleted\n", key)
+
+	case "cas":
+		if len(args) != 4 {
+			_, _ = fmt.Fprintln(output, "Usage: cas <key> <old> <new>")
+			return nil
+		}
+		key := args[1]
+		swapped, err := db.CompareAndSwap(key, args[2], args[3])
+		if err != nil {
+			return fmt.Errorf("failed to swap key %q: %w", key, err)
+		}
+		if !swapped {
+			_, _ = fmt.Fprintf(output, "Key %q was not swapped: value is not %q\n", key, args[2])
+			return nil
+		}
+		_, _ = fmt.Fprintf(output, "Key %q was swapped to %q\n", key, args[3])
+
+	case "setnx":
+		if len(args) != 3 {
+			_, _ = fmt.Fprintln(output, "Usage: setnx <key> <value>")
+			return nil
+		}
+		key := args[1]
+		set, err := db.SetIfAbsent(key, args[2])
+		if err != nil {
+			return fmt.Errorf("failed to set value: %w", err)
+		}
+		if !set {
+			_, _ = fmt.Fprintf(output, "Key %q already exists\n", key)
+			return nil
+		}
+		_, _ = fmt.Fprintf(output, "SET key=%s value=%s\n", key, args[2])
+
+	case "delifeq":
+		if len(args) != 3 {
+			_, _ = fmt.Fprintln(output, "Usage: delifeq <key> <value>")
+			return nil
+		}
+		key := args[1]
+		deleted, err := db.DeleteIfEquals(key, args[2])
+		if err != nil {
+			return fmt.Errorf("failed to delete key %q: %w", key, err)
+		}
+		if !deleted {
+			_, _ = fmt.Fprintf(output, "Key %q was not deleted: value is not %q\n", key, args[2])
+			return nil
+		}
+		_, _ = fmt.Fprintf(output, "Key %q was deleted\n", key)
+
+	case "keys":
+		if len(args) != 1 {
+			_, _ = fmt.Fprintln(output, "Usage: keys")
+			return nil
+		}
+		return printKeys(db.Iterator("", ""), output)
+
+	case "scan":
+		if len(args) != 2 && len(args) != 3 {
+			_, _ = fmt.Fprintln(output, "Usage: scan <start> [<end>]")
+			return nil
+		}
+		end := ""
+		if len(args) == 3 {
+			end = args[2]
+		}
+		return printKeys(db.Iterator(args[1], end), output)
+
+	case "prefix":
+		if len(args) != 2 {
+			_, _ = fmt.Fprintln(output, "Usage: prefix <prefix>")
+			return nil
+		}
+		return printKeys(db.PrefixIterator(args[1]), output)
+
+	case "merge":
+		if len(args) != 1 {
+			_, _ = fmt.Fprintln(output, "Usage: merge")
+			return nil
+		}
+		if err := db.Merge(); err != nil {
+			return fmt.Errorf("failed to merge: %w", err)
+		}
+		_, _ = fmt.Fprintln(output, "Merge completed")
+
+	case "exit", "quit":
+		return errExit
+
+	default:
+		_, _ = fmt.Fprintf(output, "Unknown command: %s\n", command)
+	}
+
+	return nil
+}
+
+// printKeys prints every key of the iterator with its value.
+func printKeys(it *gocask.Iterator, output io.Writer) error {
+	defer it.Close()
+
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	count := 0
+	for key, value := range it.All() {
+		_, _ = fmt.Fprintf(output, "%q = %q\n", key, value)
+		count++
+	}
+
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	if count == 0 {
+		_, _ = fmt.Fprintln(output, "No keys")
+	}
+
+	return nil
+}
+
+func runMigrate(dbPath string, args []string, output io.Writer) error {
+	if len(args) != 1 {
+		_, _ = fmt.Fprintln(output, "Usage: migrate")
+		return nil
+	}
+
+	migrated, err := gocask.Migrate(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to migrate: %w", err)
+	}
+	_, _ = fmt.Fprintf(output, "Migrated %d data files\n", migrated)
+
+	return nil
+}
+
+func printUsage(output io.Writer) {
+	_, _ = fmt.Fprintln(output, `
+Usage: gocask [options] <command> [args]
+
+Options:
+  --db <path>                Path to the database (default "./database")
+  --read-only                Open the database read-only
+  --config <file>            Read settings from a TOML or JSON config file
+  --max-file-size <bytes>    Bytes of records per data file (default 100 MB)
+  --max-key-size <bytes>     Largest key writes accept
+  --max-value-size <bytes>   Largest value writes accept
+  --blob-threshold <bytes>   Values above this size go to blob files (default 1 MB)
+  --sync <policy>            When to sync writes: never, always or interval
+  --sync-interval <duration> How often the interval policy syncs (default 1s)
+  --group-commit             Append concurrent writes together
+  --corruption <policy>      What to do with corrupted records: skip, fail or quarantine
+  --sweep-interval <duration>
+                             How often expired keys are evicted (default 1m)
+  --history-versions <n>     Replaced versions of each key merges keep
+  --history-age <duration>   How long merges keep replaced versions
+  --file-mode <mode>         Octal permissions of created files (default 0644)
+  --merge-interval <duration>
+                             How often to check whether to merge, 0 to never
+  --merge-min-files <n>      Immutable data files that trigger a merge (default 2)
+  --log-level <level>        Lowest level logged to stderr: debug, info, warn, error or off
+  -h, --help                 Show this help message
+
+Configuration:
+  Settings are read from the config file, then from GOCASK_* environment
+  variables, then from flags, each overriding the one before. The config file
+  is --config or GOCASK_CONFIG. Its keys are the flag names with underscores,
+  like max_file_size = 1048576, and the variables are the keys in upper case,
+  like GOCASK_MAX_FILE_SIZE.
+
+Commands (single-command mode):
+  set <key> <value>     Store a value
+  set <key> <value> ex <seconds>
+                        Store a value that expires after the given seconds
+  get <key>             Retrieve a value
+  ttl <key>             Show how long a value has left before it expires
+  history <key>         List the versions of a key still kept in the log
+  del <key>             Delete a value
+  cas <key> <old> <new> Replace a value only if it is <old>
+  setnx <key> <value>   Store a value only if the key does not exist
+  delifeq <key> <value> Delete a value only if it is <value>
+  keys                  List every key and its value in order
+  scan <start> [<end>]  List the keys from <start> up to, not including, <end>
+  prefix <prefix>       List the keys that start with <prefix>
+  merge                 Compact immutable data files
+  migrate               Upgrade data files to the current format
+
+Interactive mode:
+  Simply run 'gocask' without commands to enter interactive REPL.
+  Type commands like 'set <key> <value>', 'get <key>' or 'del <key>'.
+  Use 'exit' or Ctrl+D to quit.
+
+Examples:
+  gocask set name Sirius --db ./mydb
+  gocask get name --db ./mydb
+  gocask # enter interactive mode
+    `)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSingleCommandSetAndGet(t *testing.T) {
+	dir := t.TempDir()
+
+	// 1. Run "set"
+	out := &bytes.Buffer{}
+	err := run([]string{"--db", dir, "set", "foo", "bar"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "SET key=foo value=bar")
+
+	// 2. Run "get"
+	out.Reset()
+	err = run([]string{"--db", dir, "get", "foo"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "Value for key \"foo\" is \"bar\"")
+}
+
+func TestRunSingleCommandGetMissingKey(t *testing.T) {
+	dir := t.TempDir()
+
+	out := &bytes.Buffer{}
+	err := run([]string{"--db", dir, "get", "nope"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "No value for key \"nope\"")
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	dir := t.TempDir()
+
+	out := &bytes.Buffer{}
+	err := run([]string{"--db", dir, "foobar"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "Unknown command: foobar")
+}
+
+func TestRunREPLMode(t *testing.T) {
+	dir := t.TempDir()
+
+	// simulate user typing two commands then EOF
+	input := strings.NewReader("set k v\nget k\n")
+
+	out := &bytes.Buffer{}
+	err := run([]string{"--db", dir}, input, out)
+	require.NoError(t, err)
+
+	s := out.String()
+	require.Contains(t, s, "gocask service ready")
+	require.Contains(t, s, "SET key=k value=v")
+	require.Contains(t, s, "Value for key \"k\" is \"v\"")
+}
+
+func TestRunREPLExit(t *testing.T) {
+	dir := t.TempDir()
+
+	input := strings.NewReader("set k v\nexit\nset k w\n")
+
+	out := &bytes.Buffer{}
+	err := run([]string{"--db", dir}, input, out)
+	require.NoError(t, err)
+	require.NotContains(t, out.String(), "SET key=k value=w")
+
+	// The database was closed, so it opens again with the value written
+	out.Reset()
+	err = run([]string{"--db", dir, "get", "k"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "Value for key \"k\" is \"v\"")
+}
+
+func TestPrintUsage(t *testing.T) {
+	out := &bytes.Buffer{}
+	printUsage(out)
+	require.Contains(t, out.String(), "Usage: gocask")
+	require.Contains(t, out.String(), "set <key> <value>")
+	require.Contains(t, out.String(), "get <key>")
+}
+
+func TestRunMergeCommand(t *testing.T) {
+	dir := t.TempDir()
+
+	input := strings.NewReader("set k v1\nset k v2\nmerge\nget k\n")
+
+	out := &bytes.Buffer{}
+	err := run([]string{"--db", dir}, input, out)
+	require.NoError(t, err)
+
+	s := out.String()
+	require.Contains(t, s, "Merge completed")
+	require.Contains(t, s, "Value for key \"k\" is \"v2\"")
+}
+
+// writeLegacyFile writes a data file without a header holding one record in
+// the format used before records had flags:
+// CRC | Timestamp | KeySize | ValueSize | Key | Value
+func writeLegacyFile(t *testing.T, path string, key, value string) {
+	record := make([]byte, 20+len(key)+len(value))
+	binary.LittleEndian.PutUint64(record[4:], 1694280000)
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(record[16:], uint32(len(value)))
+	copy(record[20:], key)
+	copy(record[20+len(key):], value)
+	binary.LittleEndian.PutUint32(record, crc32.ChecksumIEEE(record[4:]))
+
+	require.NoError(t, os.WriteFile(path, record, 0644))
+}
+
+func TestRunMigrate(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyFile(t, filepath.Join(dir, "data.1.cask"), "key1", "value1")
+
+	out := &bytes.Buffer{}
+	err := run([]string{"--db", dir, "migrate"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "Migrated 1 data files")
+
+	out.Reset()
+	err = run([]string{"--db", dir, "get", "key1"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "Value for key \"key1\" is \"value1\"")
+}
+
+func TestRunSetWithExpiryAndTTL(t *testing.T) {
+	dir := t.TempDir()
+
+	out := &bytes.Buffer{}
+	err := run([]string{"--db", dir, "set", "foo", "bar", "ex", "60"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "SET key=foo value=bar ex=60s")
+
+	out.Reset()
+	err = run([]string{"--db", dir, "ttl", "foo"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "Key \"foo\" expires in 60s")
+
+	out.Reset()
+	err = run([]string{"--db", dir, "set", "baz", "qux"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	out.Reset()
+	err = run([]string{"--db", dir, "ttl", "baz"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "Key \"baz\" does not expire")
+
+	out.Reset()
+	err = run([]string{"--db", dir, "set", "foo", "bar", "ex", "soon"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "Usage: set <key> <value> [ex <seconds>]")
+}
+
+func TestRunHistory(t *testing.T) {
+	dir := t.TempDir()
+
+	out := &bytes.Buffer{}
+	for _, args := range [][]string{{"set", "foo", "bar"}, {"del", "foo"}, {"set", "foo", "baz"}} {
+		err := run(append([]string{"--db", dir}, args...), strings.NewReader(""), out)
+		require.NoError(t, err)
+	}
+
+	out.Reset()
+	err := run([]string{"--db", dir, "history", "foo"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	require.True(t, strings.HasSuffix(lines[0], ` "bar"`))
+	require.True(t, strings.HasSuffix(lines[1], " deleted"))
+	require.True(t, strings.HasSuffix(lines[2], ` "baz"`))
+
+	out.Reset()
+	err = run([]string{"--db", dir, "history", "missing"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "No history for key \"missing\"")
+}
+
+func TestRunConditionalWrites(t *testing.T) {
+	dir := t.TempDir()
+	input := strings.NewReader("setnx k v1\nsetnx k v2\ncas k v2 v3\ncas k v1 v3\ndelifeq k v1\ndelifeq k v3\nget k\n")
+
+	out := &bytes.Buffer{}
+	err := run([]string{"--db", dir}, input, out)
+	require.NoError(t, err)
+
+	s := out.String()
+	require.Contains(t, s, "SET key=k value=v1")
+	require.Contains(t, s, "Key \"k\" already exists")
+	require.Contains(t, s, "Key \"k\" was not swapped: value is not \"v2\"")
+	require.Contains(t, s, "Key \"k\" was swapped to \"v3\"")
+	require.Contains(t, s, "Key \"k\" was not deleted: value is not \"v1\"")
+	require.Contains(t, s, "Key \"k\" was deleted")
+	require.Contains(t, s, "No value for key \"k\"")
+}
+
+func TestRunKeysScanAndPrefix(t *testing.T) {
+	dir := t.TempDir()
+	input := strings.NewReader("set b 2\nset a 1\nset c 3\nkeys\nscan b c\nprefix c\nprefix z\n")
+
+	out := &bytes.Buffer{}
+	err := run([]string{"--db", dir}, input, out)
+	require.NoError(t, err)
+
+	s := out.String()
+	require.Contains(t, s, "> \"a\" = \"1\"\n\"b\" = \"2\"\n\"c\" = \"3\"\n")
+	require.Contains(t, s, "> \"b\" = \"2\"\n> ")
+	require.Contains(t, s, "> \"c\" = \"3\"\n> ")
+	require.Contains(t, s, "> No keys")
+}
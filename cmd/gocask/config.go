@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sebapastore/gocask"
+)
+
+// config is everything the CLI needs to open a database. Each setting can
+// come from the config file, an environment variable or a flag, and each of
+// those overrides the one before.
+type config struct {
+	dbPath   string
+	logLevel string
+	opts     gocask.Options
+}
+
+// setting is one configuration value. Its name is the key in the config
+// file, the flag is the name with dashes and the environment variable is the
+// name in upper case prefixed with GOCASK_.
+type setting struct {
+	name    string
+	usage   string
+	boolean bool
+	set     func(c *config, value string) error
+}
+
+func (s setting) flagName() string {
+	return strings.ReplaceAll(s.name, "_", "-")
+}
+
+func (s setting) envName() string {
+	return "GOCASK_" + strings.ToUpper(s.name)
+}
+
+var settings = []setting{
+	{name: "db", usage: `Path to the database (default "./database")`, set: func(c *config, v string) error {
+		c.dbPath = v
+		return nil
+	}},
+	{name: "read_only", usage: "Open the database read-only", boolean: true, set: func(c *config, v string) error {
+		return parseBool(v, &c.opts.ReadOnly)
+	}},
+	{name: "max_file_size", usage: "Bytes of records per data file (default 100 MB)", set: func(c *config, v string) error {
+		size, err := strconv.ParseUint(v, 0, 64)
+		c.opts.MaxFileSize = size
+		return err
+	}},
+	{name: "max_key_size", usage: "Largest key in bytes writes accept", set: func(c *config, v string) error {
+		size, err := strconv.ParseUint(v, 0, 64)
+		c.opts.MaxKeySize = size
+		return err
+	}},
+	{name: "max_value_size", usage: "Largest value in bytes writes accept", set: func(c *config, v string) error {
+		size, err := strconv.ParseUint(v, 0, 64)
+		c.opts.MaxValueSize = size
+		return err
+	}},
+	{name: "blob_threshold", usage: "Values above this size in bytes go to blob files (default 1 MB)", set: func(c *config, v string) error {
+		size, err := strconv.ParseUint(v, 0, 64)
+		c.opts.BlobThreshold = size
+		return err
+	}},
+	{name: "sync", usage: "When to sync writes: never, always or interval", set: func(c *config, v string) error {
+		policies := map[string]gocask.SyncPolicy{
+			"never":    gocask.SyncNever,
+			"always":   gocask.SyncAlways,
+			"interval": gocask.SyncInterval,
+		}
+		return parseChoice(v, policies, &c.opts.SyncPolicy)
+	}},
+	{name: "sync_interval", usage: "How often the interval policy syncs (default 1s)", set: func(c *config, v string) error {
+		return parseDuration(v, &c.opts.SyncInterval)
+	}},
+	{name: "group_commit", usage: "Append concurrent writes together", boolean: true, set: func(c *config, v string) error {
+		return parseBool(v, &c.opts.GroupCommit)
+	}},
+	{name: "corruption", usage: "What to do with corrupted records: skip, fail or quarantine", set: func(c *config, v string) error {
+		policies := map[string]gocask.CorruptionPolicy{
+			"skip":       gocask.CorruptionSkip,
+			"fail":       gocask.CorruptionFail,
+			"quarantine": gocask.CorruptionQuarantine,
+		}
+		return parseChoice(v, policies, &c.opts.CorruptionPolicy)
+	}},
+	{name: "sweep_interval", usage: "How often expired keys are evicted (default 1m)", set: func(c *config, v string) error {
+		return parseDuration(v, &c.opts.SweepInterval)
+	}},
+	{name: "history_versions", usage: "Replaced versions of each key merges keep", set: func(c *config, v string) error {
+		return parseInt(v, &c.opts.HistoryVersions)
+	}},
+	{name: "history_age", usage: "How long merges keep replaced versions", set: func(c *config, v string) error {
+		return parseDuration(v, &c.opts.HistoryAge)
+	}},
+	{name: "file_mode", usage: "Octal permissions of created files (default 0644)", set: func(c *config, v string) error {
+		mode, err := strconv.ParseUint(strings.TrimPrefix(v, "0o"), 8, 32)
+		c.opts.FileMode = os.FileMode(mode)
+		return err
+	}},
+	{name: "merge_interval", usage: "How often to check whether to merge, 0 to never", set: func(c *config, v string) error {
+		return parseDuration(v, &c.opts.MergeInterval)
+	}},
+	{name: "merge_min_files", usage: "Immutable data files that trigger a merge (default 2)", set: func(c *config, v string) error {
+		return parseInt(v, &c.opts.MergeMinFiles)
+	}},
+	{name: "log_level", usage: "Lowest level logged to stderr: debug, info, warn, error or off", set: func(c *config, v string) error {
+		switch strings.ToLower(v) {
+		case "debug", "info", "warn", "error", "off":
+			c.logLevel = strings.ToLower(v)
+			return nil
+		}
+		return fmt.Errorf("must be one of debug, info, warn, error or off")
+	}},
+}
+
+func parseBool(v string, dst *bool) error {
+	b, err := strconv.ParseBool(v)
+	*dst = b
+	return err
+}
+
+func parseInt(v string, dst *int) error {
+	n, err := strconv.ParseInt(v, 0, 0)
+	*dst = int(n)
+	return err
+}
+
+func parseDuration(v string, dst *time.Duration) error {
+	d, err := time.ParseDuration(v)
+	*dst = d
+	return err
+}
+
+func parseChoice[T any](v string, choices map[string]T, dst *T) error {
+	choice, ok := choices[strings.ToLower(v)]
+	if !ok {
+		names := make([]string, 0, len(choices))
+		for name := range choices {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("must be one of %s", strings.Join(names, ", "))
+	}
+	*dst = choice
+	return nil
+}
+
+func lookupSetting(name string) (setting, bool) {
+	for _, s := range settings {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// flagValue collects the flags given on the command line, so they can be
+// applied after the config file and the environment.
+type flagValue struct {
+	setting setting
+	given   *[]flagSetting
+}
+
+type flagSetting struct {
+	setting setting
+	value   string
+}
+
+func (f flagValue) String() string {
+	return ""
+}
+
+func (f flagValue) Set(value string) error {
+	*f.given = append(*f.given, flagSetting{setting: f.setting, value: value})
+	return nil
+}
+
+func (f flagValue) IsBoolFlag() bool {
+	return f.setting.boolean
+}
+
+// loadConfig builds the config from the defaults, the config file at path,
+// the environment and the flags, in that order. Without a path the file named
+// by GOCASK_CONFIG is used, if any.
+func loadConfig(path string, lookupEnv func(string) (string, bool), flags []flagSetting) (config, error) {
+	cfg := config{dbPath: "./database"}
+
+	if path == "" {
+		path, _ = lookupEnv("GOCASK_CONFIG")
+	}
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return config{}, err
+		}
+
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			s, ok := lookupSetting(name)
+			if !ok {
+				return config{}, fmt.Errorf("unknown setting %q in %s", name, path)
+			}
+			if err := s.set(&cfg, values[name]); err != nil {
+				return config{}, fmt.Errorf("invalid %s %q in %s: %w", name, values[name], path, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := lookupEnv(s.envName())
+		if !ok {
+			continue
+		}
+		if err := s.set(&cfg, value); err != nil {
+			return config{}, fmt.Errorf("invalid %s %q: %w", s.envName(), value, err)
+		}
+	}
+
+	for _, f := range flags {
+		if err := f.setting.set(&cfg, f.value); err != nil {
+			return config{}, fmt.Errorf("invalid --%s %q: %w", f.setting.flagName(), f.value, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// logger returns the logger of the configured level, or nil for the default
+// one.
+func (c config) logger(output io.Writer) *slog.Logger {
+	var level slog.Level
+	switch c.logLevel {
+	case "":
+		return nil
+	case "off":
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	case "debug":
+		level = slog.LevelDebug
+	case "info":
+		level = slog.LevelInfo
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	}
+
+	return slog.New(slog.NewTextHandler(output, &slog.HandlerOptions{Level: level}))
+}
+
+// readConfigFile reads the settings of a config file. Files ending in .json
+// are JSON objects, any other file is TOML.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]string
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		values, err = parseJSONConfig(data)
+	} else {
+		values, err = parseTOMLConfig(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+// parseJSONConfig parses a flat JSON object of strings, numbers and booleans.
+func parseJSONConfig(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var raw map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, v := range raw {
+		switch v := v.(type) {
+		case string:
+			values[name] = v
+		case json.Number:
+			values[name] = v.String()
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("setting %q must be a string, number or boolean", name)
+		}
+	}
+
+	return values, nil
+}
+
+// parseTOMLConfig parses the part of TOML a flat config needs: one
+// key = value pair per line, with basic or literal strings, numbers and
+// booleans, and # comments. Tables are not supported.
+func parseTOMLConfig(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", n)
+		}
+
+		name, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("line %d: missing key", n)
+		}
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", n, name)
+		}
+
+		value, err := parseTOMLValue(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		values[name] = value
+	}
+
+	return values, scanner.Err()
+}
+
+func parseTOMLValue(s string) (string, error) {
+	var value, rest string
+	switch {
+	case strings.HasPrefix(s, `"`):
+		var err error
+		value, rest, err = parseTOMLBasicString(s[1:])
+		if err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(s, "'"):
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		value, rest = s[1:end+1], s[end+2:]
+	default:
+		value, _, _ = strings.Cut(s, "#")
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return "", fmt.Errorf("missing value")
+		}
+	}
+
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after value", rest)
+	}
+
+	return value, nil
+}
+
+// tomlEscapes maps the escape sequences TOML allows in basic strings, apart
+// from \u and \U, to the characters they stand for.
+var tomlEscapes = map[byte]byte{
+	'b':  '\b',
+	't':  '\t',
+	'n':  '\n',
+	'f':  '\f',
+	'r':  '\r',
+	'"':  '"',
+	'\\': '\\',
+}
+
+// parseTOMLBasicString parses the basic string s starts with, after its
+// opening quote, and returns its value and what follows the closing quote.
+// Only the escapes TOML defines are accepted, unlike Go string literals.
+func parseTOMLBasicString(s string) (string, string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return b.String(), s[i+1:], nil
+		case c == '\\':
+			if i+1 == len(s) {
+				return "", "", fmt.Errorf("unterminated string")
+			}
+			i++
+
+			if r, ok := tomlEscapes[s[i]]; ok {
+				b.WriteByte(r)
+				continue
+			}
+
+			var digits int
+			switch s[i] {
+			case 'u':
+				digits = 4
+			case 'U':
+				digits = 8
+			default:
+				return "", "", fmt.Errorf("invalid escape %q in string", s[i-1:i+1])
+			}
+			if i+digits >= len(s) {
+				return "", "", fmt.Errorf("invalid escape %q in string", s[i-1:])
+			}
+			escape := s[i-1 : i+1+digits]
+			code, err := strconv.ParseUint(s[i+1:i+1+digits], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", "", fmt.Errorf("invalid escape %q in string", escape)
+			}
+			b.WriteRune(rune(code))
+			i += digits
+		case c < 0x20 && c != '\t' || c == 0x7f:
+			return "", "", fmt.Errorf("control character %q in string", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sebapastore/gocask"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func envMap(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func TestParseTOMLConfig(t *testing.T) {
+	values, err := parseTOMLConfig([]byte(`
+# gocask.toml
+db = "./data # not a comment"
+read_only = true
+max_file_size = 1_048_576 # 1 MB
+history_age = '1h30m'
+file_mode = 0o600
+sync = "\u0061lways\t\"\\"
+`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"db":            "./data # not a comment",
+		"read_only":     "true",
+		"max_file_size": "1_048_576",
+		"history_age":   "1h30m",
+		"file_mode":     "0o600",
+		"sync":          "always\t\"\\",
+	}, values)
+
+	for content, msg := range map[string]string{
+		"[database]\ndb = \"x\"": "line 1: tables are not supported",
+		"db \"x\"":               "line 1: expected key = value",
+		"db = \"x\ndb = \"y\"":   "line 1: unterminated string",
+		"db = \"x\"\ndb = \"y\"": `line 2: duplicate key "db"`,
+		"db = \"x\" y":           `line 1: unexpected "y" after value`,
+		"db =":                   "line 1: missing value",
+		`db = "\x41"`:            `line 1: invalid escape "\\x" in string`,
+		`db = "\a"`:              `line 1: invalid escape "\\a" in string`,
+		`db = "\UFFFFFFFF"`:      `line 1: invalid escape "\\UFFFFFFFF" in string`,
+		`db = "\uD800"`:          `line 1: invalid escape "\\uD800" in string`,
+		`db = "\u12"`:            `line 1: invalid escape "\\u12\"" in string`,
+		"db = \"a\x01\"":         `line 1: control character '\x01' in string`,
+	} {
+		_, err := parseTOMLConfig([]byte(content))
+		require.EqualError(t, err, msg, content)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "gocask.toml", `
+db = "./from-file"
+sync = "always"
+max_file_size = 1024
+corruption = "quarantine"
+`)
+	env := envMap(map[string]string{
+		"GOCASK_SYNC":          "interval",
+		"GOCASK_SYNC_INTERVAL": "250ms",
+		"GOCASK_MAX_FILE_SIZE": "2048",
+	})
+	sync, _ := lookupSetting("sync")
+
+	cfg, err := loadConfig(path, env, []flagSetting{{setting: sync, value: "never"}})
+	require.NoError(t, err)
+	require.Equal(t, "./from-file", cfg.dbPath)
+	require.Equal(t, gocask.CorruptionQuarantine, cfg.opts.CorruptionPolicy)
+	require.Equal(t, uint64(2048), cfg.opts.MaxFileSize)
+	require.Equal(t, 250*time.Millisecond, cfg.opts.SyncInterval)
+	require.Equal(t, gocask.SyncNever, cfg.opts.SyncPolicy)
+
+	// Without --config the file comes from GOCASK_CONFIG
+	cfg, err = loadConfig("", envMap(map[string]string{"GOCASK_CONFIG": path}), nil)
+	require.NoError(t, err)
+	require.Equal(t, gocask.SyncAlways, cfg.opts.SyncPolicy)
+
+	cfg, err = loadConfig("", envMap(nil), nil)
+	require.NoError(t, err)
+	require.Equal(t, config{dbPath: "./database"}, cfg)
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	path := writeConfigFile(t, "gocask.json", `{
+		"group_commit": true,
+		"history_versions": 3,
+		"file_mode": "0640",
+		"merge_interval": "1m",
+		"blob_threshold": 4096,
+		"log_level": "WARN"
+	}`)
+
+	cfg, err := loadConfig(path, envMap(nil), nil)
+	require.NoError(t, err)
+	require.True(t, cfg.opts.GroupCommit)
+	require.Equal(t, 3, cfg.opts.HistoryVersions)
+	require.Equal(t, os.FileMode(0640), cfg.opts.FileMode)
+	require.Equal(t, time.Minute, cfg.opts.MergeInterval)
+	require.Equal(t, uint64(4096), cfg.opts.BlobThreshold)
+	require.Equal(t, "warn", cfg.logLevel)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	path := writeConfigFile(t, "gocask.toml", `max_size = 10`)
+	_, err := loadConfig(path, envMap(nil), nil)
+	require.ErrorContains(t, err, `unknown setting "max_size"`)
+
+	path = writeConfigFile(t, "gocask.json", `{"sync": "sometimes"}`)
+	_, err = loadConfig(path, envMap(nil), nil)
+	require.ErrorContains(t, err, `invalid sync "sometimes"`)
+	require.ErrorContains(t, err, "must be one of always, interval, never")
+
+	_, err = loadConfig("", envMap(map[string]string{"GOCASK_SWEEP_INTERVAL": "soon"}), nil)
+	require.ErrorContains(t, err, `invalid GOCASK_SWEEP_INTERVAL "soon"`)
+
+	_, err = loadConfig(filepath.Join(t.TempDir(), "missing.toml"), envMap(nil), nil)
+	require.ErrorContains(t, err, "failed to read config file")
+}
+
+func TestRunWithConfigFileAndEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfigFile(t, "gocask.toml", "db = '"+dir+"'\nread_only = true\n")
+
+	// The environment overrides the file, so the database is writable
+	t.Setenv("GOCASK_READ_ONLY", "false")
+	out := &bytes.Buffer{}
+	err := run([]string{"--config", path, "set", "foo", "bar"}, strings.NewReader(""), out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "SET key=foo value=bar")
+
+	// And a flag overrides the environment
+	out.Reset()
+	err = run([]string{"--config", path, "--read-only", "set", "foo", "baz"}, strings.NewReader(""), out)
+	require.ErrorIs(t, err, gocask.ErrReadOnly)
+
+	err = run([]string{"--db", dir, "--file-mode", "0444", "get", "foo"}, strings.NewReader(""), out)
+	require.ErrorIs(t, err, gocask.ErrInvalidOptions)
+
+	err = run([]string{"--db", dir, "--max-value-size", "2", "set", "foo", "baz"}, strings.NewReader(""), out)
+	require.ErrorIs(t, err, gocask.ErrValueTooLarge)
+}
@@ -0,0 +1,69 @@
+package gocask
+
+import (
+	"errors"
+
+	"github.com/sebapastore/gocask/internal/bitcask"
+)
+
+// Errors returned by the database. They are sentinel values, so callers can
+// check for them with errors.Is even when they come wrapped.
+var (
+	// ErrNotFound reports a key that does not exist or expired. GetReader
+	// returns it, while Get and the other reads that return whether the key
+	// exists report a missing key through that result instead, so callers
+	// that want an error for it can return ErrNotFound.
+	ErrNotFound = bitcask.ErrNotFound
+
+	// ErrClosed is returned by operations on a DB after Close.
+	ErrClosed = bitcask.ErrClosed
+
+	// ErrEmptyKey is returned by writes of an empty key.
+	ErrEmptyKey = bitcask.ErrEmptyKey
+
+	// ErrKeyTooLarge is returned by writes of a key larger than
+	// Options.MaxKeySize, or too large for its record to fit in a data file
+	// of Options.MaxFileSize even with an empty value.
+	ErrKeyTooLarge = bitcask.ErrKeyTooLarge
+
+	// ErrValueTooLarge is returned by writes of a value larger than
+	// Options.MaxValueSize, or whose record would not fit in a data file of
+	// Options.MaxFileSize.
+	ErrValueTooLarge = bitcask.ErrValueTooLarge
+
+	// ErrChecksumMismatch is wrapped by a CorruptionError about a record
+	// whose CRC does not match its contents.
+	ErrChecksumMismatch = bitcask.ErrChecksumMismatch
+
+	// ErrDatabaseLocked is returned by Open when another process has the
+	// database open for writing.
+	ErrDatabaseLocked = bitcask.ErrDatabaseLocked
+
+	// ErrReadOnly is returned by writes to a database opened read-only.
+	ErrReadOnly = bitcask.ErrReadOnly
+
+	// ErrUnsupportedVersion is returned by Open when a data file was written
+	// in a format version this build cannot read.
+	ErrUnsupportedVersion = bitcask.ErrUnsupportedVersion
+
+	// ErrConflict is returned by Update when another writer changed a key the
+	// transaction read before it could commit.
+	ErrConflict = bitcask.ErrConflict
+
+	// ErrTxnClosed is returned by a transaction used after its function
+	// returned.
+	ErrTxnClosed = bitcask.ErrTxnClosed
+
+	// ErrSnapshotReleased is returned by a snapshot, or an iterator created
+	// from one, used after the snapshot was released.
+	ErrSnapshotReleased = bitcask.ErrSnapshotReleased
+
+	// ErrInvalidOptions is returned by Open and Options.Validate when a
+	// setting is out of range.
+	ErrInvalidOptions = errors.New("invalid options")
+)
+
+// CorruptionError reports a record that failed to decode in the middle of a
+// data file, with the ID of the file and the offset of the record. Open
+// returns it under CorruptionFail.
+type CorruptionError = bitcask.CorruptionError
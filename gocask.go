@@ -0,0 +1,193 @@
+// Package gocask is an embedded key-value store built on the Bitcask design:
+// writes are appended to a log of data files and an in-memory index points at
+// the latest value of every key.
+//
+//	db, err := gocask.Open("./data")
+//	if err != nil {
+//		...
+//	}
+//	defer db.Close()
+//
+//	if err := db.Set("name", "Sirius"); err != nil {
+//		...
+//	}
+//	value, ok, err := db.Get("name")
+//
+// A DB is safe for concurrent use, and only one process can have a database
+// directory open for writing at a time.
+package gocask
+
+import (
+	"io"
+	"time"
+
+	"github.com/sebapastore/gocask/internal/bitcask"
+)
+
+// DB is an open database.
+type DB struct {
+	db *bitcask.Database
+}
+
+// Open opens the database at path, creating it if it does not exist yet.
+// Without options it is opened for reading and writing with the defaults
+// described on Options. Options out of range fail with ErrInvalidOptions.
+func Open(path string, opts ...Option) (*DB, error) {
+	o := DefaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	db := bitcask.NewDatabase(path, o.MaxFileSize)
+	db.SetSizeLimits(o.MaxKeySize, o.MaxValueSize)
+	db.SetBlobThreshold(o.BlobThreshold)
+	db.SetSyncPolicy(o.SyncPolicy, o.SyncInterval)
+	db.SetGroupCommit(o.GroupCommit)
+	db.SetCorruptionPolicy(o.CorruptionPolicy)
+	db.SetSweepInterval(o.SweepInterval)
+	db.SetHistoryRetention(o.HistoryVersions, o.HistoryAge)
+	db.SetFileMode(o.FileMode)
+	db.SetMergeTrigger(o.MergeInterval, o.MergeMinFiles)
+	db.SetLogger(o.Logger)
+	db.SetMetrics(o.Metrics)
+
+	open := db.Open
+	if o.ReadOnly {
+		open = db.OpenReadOnly
+	}
+	if err := open(); err != nil {
+		return nil, err
+	}
+
+	return &DB{db: db}, nil
+}
+
+// Migrate rewrites every data file at path written in an older format into
+// the current one and returns how many files it rewrote. The database must
+// not be open anywhere.
+func Migrate(path string) (int, error) {
+	return bitcask.Migrate(path)
+}
+
+// Close syncs pending writes and releases the database. The DB must not be
+// used afterwards.
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// Get returns the value of key and whether it exists.
+func (db *DB) Get(key string) (string, bool, error) {
+	return db.db.Get(key)
+}
+
+// GetBytes returns the value of key and whether it exists.
+func (db *DB) GetBytes(key []byte) ([]byte, bool, error) {
+	return db.db.GetBytes(key)
+}
+
+// Set stores value under key.
+func (db *DB) Set(key string, value string) error {
+	return db.db.Set(key, value)
+}
+
+// Put stores value under key.
+func (db *DB) Put(key []byte, value []byte) error {
+	return db.db.Put(key, value)
+}
+
+// GetReader returns a reader over the value of key, or ErrNotFound when it
+// does not exist. Values stored in blob files are streamed from disk. The
+// reader must be closed.
+func (db *DB) GetReader(key []byte) (io.ReadCloser, error) {
+	return db.db.GetReader(key)
+}
+
+// PutReader stores the size bytes read from r under key. Values above
+// Options.BlobThreshold are streamed to a blob file instead of being held in
+// memory.
+func (db *DB) PutReader(key []byte, r io.Reader, size int64) error {
+	return db.db.PutReader(key, r, size)
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (db *DB) Delete(key string) error {
+	return db.db.Delete(key)
+}
+
+// SetWithTTL stores value under key and makes it expire after ttl.
+func (db *DB) SetWithTTL(key string, value string, ttl time.Duration) error {
+	return db.db.SetWithTTL(key, value, ttl)
+}
+
+// PutWithTTL stores value under key and makes it expire after ttl.
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	return db.db.PutWithTTL(key, value, ttl)
+}
+
+// TTL returns how long key has left before it expires, and whether it exists.
+// The duration is zero for keys that never expire.
+func (db *DB) TTL(key string) (time.Duration, bool, error) {
+	return db.db.TTL(key)
+}
+
+// CompareAndSwap sets key to new only if its value is old, and reports
+// whether it did.
+func (db *DB) CompareAndSwap(key string, old string, new string) (bool, error) {
+	return db.db.CompareAndSwap(key, old, new)
+}
+
+// SetIfAbsent sets key only if it does not exist, and reports whether it did.
+func (db *DB) SetIfAbsent(key string, value string) (bool, error) {
+	return db.db.SetIfAbsent(key, value)
+}
+
+// DeleteIfEquals deletes key only if its value is value, and reports whether
+// it did.
+func (db *DB) DeleteIfEquals(key string, value string) (bool, error) {
+	return db.db.DeleteIfEquals(key, value)
+}
+
+// Write applies every write of the batch atomically: after a crash either all
+// of them are there or none is.
+func (db *DB) Write(b *Batch) error {
+	return db.db.Write(&b.b)
+}
+
+// History returns every version of key still kept in the log, oldest first.
+// It reads through the whole log, so it costs time in proportion to the size
+// of the database, not to the number of versions of key.
+func (db *DB) History(key string) ([]Version, error) {
+	return db.db.History(key)
+}
+
+// GetAt returns the value key held at the given time. Like History, it reads
+// through the whole log.
+func (db *DB) GetAt(key string, at time.Time) (string, bool, error) {
+	return db.db.GetAt(key, at)
+}
+
+// GetBytesAt returns the value key held at the given time. Like History, it
+// reads through the whole log.
+func (db *DB) GetBytesAt(key []byte, at time.Time) ([]byte, bool, error) {
+	return db.db.GetBytesAt(key, at)
+}
+
+// Merge compacts the data files that are no longer written to, dropping
+// overwritten and deleted values.
+func (db *DB) Merge() error {
+	return db.db.Merge()
+}
+
+// Sync flushes every write made so far to stable storage.
+func (db *DB) Sync() error {
+	return db.db.Sync()
+}
+
+// DiscardedBytes returns how many bytes of an incomplete trailing record Open
+// truncated from the newest data file after a crash.
+func (db *DB) DiscardedBytes() uint64 {
+	return db.db.DiscardedBytes()
+}
@@ -0,0 +1,50 @@
+package bitcask
+
+// Batch collects writes that Database.Write applies atomically: after a crash
+// either all of them are visible or none is. The zero value is an empty batch
+// ready to use.
+type Batch struct {
+	entries []*Entry
+}
+
+// Put adds a write of value under key to the batch. Both slices are kept as
+// they are, so they must not be modified until the batch is written.
+func (b *Batch) Put(key []byte, value []byte) {
+	b.entries = append(b.entries, NewEntryBytes(key, value))
+}
+
+// Delete adds a deletion of key to the batch.
+func (b *Batch) Delete(key []byte) {
+	b.entries = append(b.entries, NewTombstone(key))
+}
+
+// Len returns the number of writes in the batch.
+func (b *Batch) Len() int {
+	return len(b.entries)
+}
+
+// Write appends every write in the batch as one group of records framed by
+// begin and commit markers. Readers see either none or all of them, and Open
+// drops a batch whose commit marker never made it to disk.
+func (db *Database) Write(batch *Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	return db.write(batch.entries...)
+}
+
+// pendingBatch is a batch being read back whose commit marker was not reached
+// yet.
+type pendingBatch struct {
+	offset  uint64 // where its begin marker starts
+	entries []hintEntry
+	skipped uint32 // records a filtered scan left out of entries
+	damaged bool   // a record inside it was corrupted and skipped
+}
+
+// complete reports whether the batch holds every record its commit marker
+// counts.
+func (b *pendingBatch) complete(count uint32) bool {
+	return !b.damaged && uint32(len(b.entries))+b.skipped == count
+}
@@ -0,0 +1,202 @@
+package bitcask
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const batchMarkerSize = headerSize + 4
+
+func TestWriteBatch(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+
+	require.NoError(t, db.Set("doc", "old"))
+
+	var batch Batch
+	batch.Put([]byte("doc"), []byte("new"))
+	batch.Put([]byte("index"), []byte("doc"))
+	batch.Delete([]byte("stale"))
+	require.Equal(t, 3, batch.Len())
+	require.NoError(t, db.Write(&batch))
+
+	for _, reopen := range []bool{false, true} {
+		if reopen {
+			require.NoError(t, db.Close())
+			require.NoError(t, db.Open())
+		}
+
+		val, ok, err := db.Get("doc")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "new", val)
+
+		val, ok, err = db.Get("index")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "doc", val)
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestWriteEmptyBatch(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Write(&Batch{}))
+
+	info, err := os.Stat(filepath.Join(dir, "data.1.cask"))
+	require.NoError(t, err)
+	require.Equal(t, int64(segmentHeaderSize), info.Size())
+}
+
+func TestOpenRollsBackUncommittedBatch(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+
+	require.NoError(t, db.Set("key1", "value1")) // 30 bytes
+	var batch Batch
+	batch.Put([]byte("key1"), []byte("value2"))
+	batch.Put([]byte("key2"), []byte("value3"))
+	require.NoError(t, db.Write(&batch))
+	require.NoError(t, db.Close())
+
+	// Crash right before the commit marker reached the disk
+	path := filepath.Join(dir, "data.1.cask")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-batchMarkerSize))
+
+	require.NoError(t, db.Open())
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value1", val)
+
+	_, ok, err = db.Get("key2")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// The whole batch was cut off, so later writes are not framed by it
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(segmentHeaderSize+30), info.Size())
+	require.Equal(t, uint64(batchMarkerSize+60), db.DiscardedBytes())
+
+	require.NoError(t, db.Set("key3", "value4"))
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	val, ok, err = db.Get("key3")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value4", val)
+}
+
+func TestOpenDropsBatchWithCorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+
+	var batch Batch
+	batch.Put([]byte("key1"), []byte("value1"))
+	batch.Put([]byte("key2"), []byte("value2"))
+	require.NoError(t, db.Write(&batch))
+	require.NoError(t, db.Set("key3", "value3"))
+	require.NoError(t, db.Close())
+
+	// Corrupt the value of key2 inside the batch
+	flipByte(t, filepath.Join(dir, "data.1.cask"), segmentHeaderSize+batchMarkerSize+30+25)
+
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	for _, key := range []string{"key1", "key2"} {
+		_, ok, err := db.Get(key)
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+
+	val, ok, err := db.Get("key3")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value3", val)
+}
+
+func TestBatchNeverSpansFiles(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 128)
+	require.NoError(t, db.Open())
+
+	require.NoError(t, db.Set("key0", "value0")) // 30 bytes
+
+	// 2 markers and 3 records take 138 bytes, more than a whole file
+	var batch Batch
+	batch.Put([]byte("key1"), []byte("value1"))
+	batch.Put([]byte("key2"), []byte("value2"))
+	batch.Put([]byte("key3"), []byte("value3"))
+	require.NoError(t, db.Write(&batch))
+
+	require.Equal(t, uint64(2), db.activeFileID)
+	for _, key := range []string{"key1", "key2", "key3"} {
+		require.Equal(t, uint64(2), db.keydir[key].FileID)
+	}
+	require.NoError(t, db.Close())
+
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	val, ok, err := db.Get("key3")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value3", val)
+}
+
+func TestWriteBatchWithGroupCommit(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	db.SetGroupCommit(true)
+	require.NoError(t, db.Open())
+
+	var batch Batch
+	batch.Put([]byte("key1"), []byte("value1"))
+	batch.Delete([]byte("key1"))
+	batch.Put([]byte("key2"), []byte("value2"))
+	require.NoError(t, db.Write(&batch))
+	require.NoError(t, db.Close())
+
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	val, ok, err := db.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value2", val)
+}
+
+func TestWriteBatchReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Close())
+
+	require.NoError(t, db.OpenReadOnly())
+	defer func() { _ = db.Close() }()
+
+	var batch Batch
+	batch.Put([]byte("key1"), []byte("value1"))
+	require.ErrorIs(t, db.Write(&batch), ErrReadOnly)
+}
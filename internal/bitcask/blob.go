@@ -0,0 +1,475 @@
+package bitcask
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values larger than the blob threshold are written to a file of their own in
+// the blobs/ subdirectory, and the record holds a reference to it instead:
+// BlobID | Size | CRC
+// Blob files hold nothing but the value and are never modified. Merge removes
+// the ones no record references anymore.
+const (
+	blobDirName          = "blobs"
+	blobFileSuffix       = ".blob"
+	defaultBlobThreshold = 1024 * 1024 // 1 MB
+
+	blobIDSize   = 8 // 64 bits for the blob file ID
+	blobSizeSize = 8 // 64 bits for the value size
+	blobRefSize  = blobIDSize + blobSizeSize + crcSize
+)
+
+type blobRef struct {
+	id   uint64
+	size uint64
+	crc  uint32 // CRC of the whole value
+}
+
+func (r blobRef) encode() []byte {
+	buf := make([]byte, blobRefSize)
+	binary.LittleEndian.PutUint64(buf, r.id)
+	binary.LittleEndian.PutUint64(buf[blobIDSize:], r.size)
+	binary.LittleEndian.PutUint32(buf[blobIDSize+blobSizeSize:], r.crc)
+	return buf
+}
+
+func decodeBlobRef(value []byte) (blobRef, error) {
+	if len(value) != blobRefSize {
+		return blobRef{}, fmt.Errorf("invalid blob reference of %d bytes", len(value))
+	}
+
+	return blobRef{
+		id:   binary.LittleEndian.Uint64(value),
+		size: binary.LittleEndian.Uint64(value[blobIDSize:]),
+		crc:  binary.LittleEndian.Uint32(value[blobIDSize+blobSizeSize:]),
+	}, nil
+}
+
+// withBlob returns a copy of the entry whose value is a reference to ref.
+func (e *Entry) withBlob(ref blobRef) *Entry {
+	stored := *e
+	stored.Value = ref.encode()
+	stored.blob = true
+	return &stored
+}
+
+// SetBlobThreshold chooses the size in bytes above which values are stored in
+// blob files. Defaults to 1 MB when zero. Values above 4 GiB - 1 do not fit in
+// a record, so a larger threshold is lowered to that. It must be called before
+// Open.
+func (db *Database) SetBlobThreshold(threshold uint64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if threshold == 0 {
+		threshold = defaultBlobThreshold
+	}
+
+	db.blobThreshold = min(threshold, MaxValueLength)
+}
+
+// storesBlob reports whether the value of the entry goes to a blob file,
+// which is the case above the blob threshold and whenever the record would
+// not fit in a data file with the value inline.
+func (db *Database) storesBlob(e *Entry) bool {
+	if e.blob || e.Tombstone || e.marker != 0 {
+		return false
+	}
+	return uint64(e.ValueSize()) > db.blobThreshold || e.Size() > db.maxFileSize
+}
+
+func blobFilePath(dbPath string, id uint64) string {
+	return filepath.Join(dbPath, blobDirName, strconv.FormatUint(id, 10)+blobFileSuffix)
+}
+
+// PutReader stores the size bytes read from r under key. Values above the blob
+// threshold, or too large for a data file, are streamed to a blob file without
+// being held in memory. It fails with io.ErrUnexpectedEOF if r ends early.
+func (db *Database) PutReader(key []byte, r io.Reader, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid value size %d", size)
+	}
+
+	entry := NewEntryBytes(key, nil).withBlob(blobRef{size: uint64(size)})
+	if err := db.checkEntry(entry); err != nil {
+		return err
+	}
+
+	inlineSize := uint64(headerSize) + uint64(len(key)) + uint64(size)
+	if uint64(size) <= db.blobThreshold && inlineSize <= db.maxFileSize {
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return fmt.Errorf("failed to read value: %w", err)
+		}
+		return db.Put(key, value)
+	}
+
+	ref, err := db.createBlob(r, uint64(size))
+	if err != nil {
+		return err
+	}
+	defer db.releaseBlobs([]uint64{ref.id})
+
+	return db.write(entry.withBlob(ref))
+}
+
+// GetReader returns a reader over the value of key, or ErrNotFound when the
+// key is missing. Values in blob files are streamed from them, and reading
+// one to the end fails with ErrChecksumMismatch if it does not match its
+// checksum. The reader must be closed.
+func (db *Database) GetReader(key []byte) (io.ReadCloser, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	meta, err := db.lookup(string(key), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := db.readRecordValue(meta)
+	if err != nil {
+		return nil, err
+	}
+	db.metrics.Count(MetricReads, 1)
+
+	if !meta.Blob {
+		return io.NopCloser(bytes.NewReader(value)), nil
+	}
+
+	ref, err := decodeBlobRef(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// The open file stays readable even if a merge removes the blob
+	f, err := os.Open(blobFilePath(db.dbPath, ref.id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob %d: %w", ref.id, err)
+	}
+
+	hash := crc32.NewIEEE()
+	return &blobReader{
+		f:    f,
+		r:    io.TeeReader(io.LimitReader(f, int64(ref.size)), hash),
+		hash: hash,
+		ref:  ref,
+	}, nil
+}
+
+// blobReader streams a blob and checks its size and CRC once it reaches the
+// end.
+type blobReader struct {
+	f    *os.File
+	r    io.Reader
+	hash hash.Hash32
+	ref  blobRef
+	read uint64
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += uint64(n)
+	if err != io.EOF {
+		return n, err
+	}
+
+	if b.read != b.ref.size {
+		return n, fmt.Errorf("failed to read blob %d: %w", b.ref.id, io.ErrUnexpectedEOF)
+	}
+	if b.hash.Sum32() != b.ref.crc {
+		return n, fmt.Errorf("%w for blob %d", ErrChecksumMismatch, b.ref.id)
+	}
+
+	return n, io.EOF
+}
+
+func (b *blobReader) Close() error {
+	return b.f.Close()
+}
+
+// readBlob reads the whole value a blob reference points at.
+func readBlob(dbPath string, value []byte) ([]byte, error) {
+	ref, err := decodeBlobRef(value)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(blobFilePath(dbPath, ref.id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob %d: %w", ref.id, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	blob := make([]byte, ref.size)
+	if _, err := io.ReadFull(f, blob); err != nil {
+		return nil, fmt.Errorf("failed to read blob %d: %w", ref.id, err)
+	}
+	if crc32.ChecksumIEEE(blob) != ref.crc {
+		return nil, fmt.Errorf("%w for blob %d", ErrChecksumMismatch, ref.id)
+	}
+
+	return blob, nil
+}
+
+// storeBlobs writes the values above the blob threshold to blob files. It
+// returns the entries to append, where those values are replaced by
+// references, and the IDs of the new blobs, which the caller must pass to
+// releaseBlobs once the entries are appended. The given entries are left
+// untouched.
+func (db *Database) storeBlobs(entries []*Entry) ([]*Entry, []uint64, error) {
+	var stored []*Entry
+	var ids []uint64
+	for i, e := range entries {
+		if !db.storesBlob(e) {
+			continue
+		}
+
+		ref, err := db.createBlob(bytes.NewReader(e.Value), uint64(e.ValueSize()))
+		if err != nil {
+			db.mu.Lock()
+			db.removeBlobs(ids)
+			db.mu.Unlock()
+			return nil, nil, err
+		}
+		ids = append(ids, ref.id)
+
+		if stored == nil {
+			stored = slices.Clone(entries)
+		}
+		stored[i] = e.withBlob(ref)
+	}
+
+	if stored == nil {
+		return entries, nil, nil
+	}
+
+	return stored, ids, nil
+}
+
+// createBlob writes size bytes from r to a new blob file, which is synced
+// before it is referenced from any record. The blob stays pending, so merge
+// does not take it for an orphan, until releaseBlobs.
+func (db *Database) createBlob(r io.Reader, size uint64) (blobRef, error) {
+	db.mu.Lock()
+	if db.readOnly {
+		db.mu.Unlock()
+		return blobRef{}, ErrReadOnly
+	}
+	if db.activeFile == nil {
+		db.mu.Unlock()
+		return blobRef{}, ErrClosed
+	}
+	id := db.nextBlobID
+	db.nextBlobID++
+	db.pendingBlobs[id] = struct{}{}
+	db.mu.Unlock()
+
+	ref, err := db.writeBlobFile(id, r, size)
+	if err != nil {
+		db.mu.Lock()
+		db.removeBlobs([]uint64{id})
+		db.mu.Unlock()
+		return blobRef{}, err
+	}
+
+	return ref, nil
+}
+
+func (db *Database) writeBlobFile(id uint64, r io.Reader, size uint64) (blobRef, error) {
+	dir := filepath.Join(db.dbPath, blobDirName)
+	if err := os.Mkdir(dir, db.dirMode()); err == nil {
+		if err := syncDir(db.dbPath); err != nil {
+			return blobRef{}, err
+		}
+	} else if !errors.Is(err, fs.ErrExist) {
+		return blobRef{}, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	f, err := os.OpenFile(blobFilePath(db.dbPath, id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, db.fileMode)
+	if err != nil {
+		return blobRef{}, fmt.Errorf("failed to create blob %d: %w", id, err)
+	}
+
+	hash := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(r, int64(size)))
+	if err == nil && uint64(n) < size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return blobRef{}, fmt.Errorf("failed to write blob %d: %w", id, err)
+	}
+
+	if err := syncDir(dir); err != nil {
+		return blobRef{}, err
+	}
+	db.metrics.Count(MetricBytesWritten, n)
+
+	return blobRef{id: id, size: size, crc: hash.Sum32()}, nil
+}
+
+// releaseBlobs ends the pending state of blobs created by createBlob. Blobs
+// whose record never made it to the log become orphans that the next merge
+// removes. The caller must not hold mu.
+func (db *Database) releaseBlobs(ids []uint64) {
+	if len(ids) == 0 {
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, id := range ids {
+		delete(db.pendingBlobs, id)
+	}
+}
+
+// removeBlobs removes pending blobs right away, for writes that gave up
+// before appending anything. The caller must hold mu exclusively.
+func (db *Database) removeBlobs(ids []uint64) {
+	for _, id := range ids {
+		delete(db.pendingBlobs, id)
+		_ = os.Remove(blobFilePath(db.dbPath, id))
+	}
+}
+
+// blobIDs returns the IDs of the blob files on disk.
+func (db *Database) blobIDs() (map[uint64]struct{}, error) {
+	files, err := filepath.Glob(filepath.Join(db.dbPath, blobDirName, "*"+blobFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blob files: %w", err)
+	}
+
+	ids := make(map[uint64]struct{}, len(files))
+	for _, f := range files {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(f), blobFileSuffix), 10, 64)
+		if err == nil {
+			ids[id] = struct{}{}
+		}
+	}
+
+	return ids, nil
+}
+
+// loadNextBlobID numbers new blobs after the ones already on disk. The
+// caller must hold mu.
+func (db *Database) loadNextBlobID() error {
+	ids, err := db.blobIDs()
+	if err != nil {
+		return err
+	}
+
+	db.nextBlobID = 1
+	for id := range ids {
+		db.nextBlobID = max(db.nextBlobID, id+1)
+	}
+
+	return nil
+}
+
+// collectBlobs removes the blob files that no record of the log references,
+// which after a merge includes those of the values it dropped. The merge
+// reports the blobs referenced by the records it copied into the segments up
+// to mergedID, so only the newer segments are scanned for the others. Blobs
+// that are still pending are kept. Snapshots, transactions and iterators may
+// still read a blob their pinned files reference, so while any state is
+// pinned the orphans are only removed once the last one is released.
+func (db *Database) collectBlobs(mergedID uint64, referenced map[uint64]struct{}) error {
+	db.mu.Lock()
+	orphans, err := db.blobIDs()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	for id := range db.pendingBlobs {
+		delete(orphans, id)
+	}
+	for id := range referenced {
+		delete(orphans, id)
+	}
+	if len(orphans) == 0 {
+		db.mu.Unlock()
+		return nil
+	}
+
+	// Records appended from now on only reference pending or new blobs
+	sizes, err := db.fileSizes()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	for id := range sizes {
+		if id <= mergedID {
+			delete(sizes, id)
+		}
+	}
+	state := db.pin()
+	db.mu.Unlock()
+
+	var readErr error
+	err = db.scanLog(state, sizes, nil, func(h hintEntry) {
+		if !h.meta.Blob || readErr != nil {
+			return
+		}
+
+		value, err := state.readRecordValue(h.meta)
+		if err == nil {
+			var ref blobRef
+			ref, err = decodeBlobRef(value)
+			delete(orphans, ref.id)
+		}
+		readErr = err
+	})
+	if err == nil {
+		err = readErr
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.unpin(state)
+	if err != nil {
+		return fmt.Errorf("failed to collect blobs: %w", err)
+	}
+
+	for id := range orphans {
+		db.orphanBlobs[id] = struct{}{}
+	}
+	db.removeOrphanBlobs()
+
+	return nil
+}
+
+// removeOrphanBlobs removes the blobs collectBlobs found unreferenced, unless
+// a pinned state may still read them. The caller must hold mu exclusively.
+func (db *Database) removeOrphanBlobs() {
+	if len(db.fileRefs) > 0 {
+		return
+	}
+
+	for id := range db.orphanBlobs {
+		if err := removeIfExists(blobFilePath(db.dbPath, id)); err != nil {
+			db.logger.Warn("failed to remove orphaned blob", "blob_id", id, "error", err)
+			continue
+		}
+		delete(db.orphanBlobs, id)
+	}
+}
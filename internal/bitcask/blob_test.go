@@ -0,0 +1,271 @@
+package bitcask
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// blobFiles returns the names of the blob files in dir.
+func blobFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, blobDirName, "*"+blobFileSuffix))
+	require.NoError(t, err)
+
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = filepath.Base(f)
+	}
+	return names
+}
+
+func readAll(t *testing.T, db *Database, key string) string {
+	t.Helper()
+
+	r, err := db.GetReader([]byte(key))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	value, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(value)
+}
+
+func TestPutReaderAndGetReader(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	db.SetBlobThreshold(16)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	large := strings.Repeat("v", 100)
+	require.NoError(t, db.PutReader([]byte("key1"), strings.NewReader(large), 100))
+	require.NoError(t, db.PutReader([]byte("key2"), strings.NewReader("value2"), 6))
+	require.Equal(t, []string{"1.blob"}, blobFiles(t, dir))
+
+	// Only the reference goes in the data file
+	require.Equal(t, int64(segmentHeaderSize+headerSize+4+blobRefSize+headerSize+4+6), activeFileSize(t, db))
+
+	require.Equal(t, large, readAll(t, db, "key1"))
+	require.Equal(t, "value2", readAll(t, db, "key2"))
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, large, val)
+
+	_, err = db.GetReader([]byte("missing"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// A reader that ends early writes nothing
+	err = db.PutReader([]byte("key3"), strings.NewReader(large), 200)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = db.GetReader([]byte("key3"))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.Equal(t, []string{"1.blob"}, blobFiles(t, dir))
+
+	// Put stores large values in blob files too. The failed write used up
+	// the second ID.
+	require.NoError(t, db.Set("key3", large+"3"))
+	require.Equal(t, []string{"1.blob", "3.blob"}, blobFiles(t, dir))
+	require.Equal(t, large+"3", readAll(t, db, "key3"))
+}
+
+func TestBlobsAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 100)
+	db.SetBlobThreshold(16)
+	require.NoError(t, db.Open())
+
+	large := strings.Repeat("v", 1000)
+	require.NoError(t, db.Set("key1", large+"1"))
+	require.NoError(t, db.Set("key2", large+"2"))
+	require.NoError(t, db.Set("key3", large+"3")) // rotates and writes data.1.hint
+	require.NoError(t, db.Close())
+
+	db = NewDatabase(dir, 100)
+	db.SetBlobThreshold(16)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	for _, key := range []string{"key1", "key2", "key3"} {
+		val, ok, err := db.Get(key)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, large+key[3:], val)
+	}
+
+	snap, err := db.Snapshot()
+	require.NoError(t, err)
+	defer snap.Release()
+
+	it := snap.Iterator("", "")
+	require.True(t, it.Next())
+	value, err := it.Value()
+	require.NoError(t, err)
+	require.Equal(t, large+"1", string(value))
+	it.Close()
+
+	// New blobs are numbered after the existing ones
+	require.NoError(t, db.Set("key4", large+"4"))
+	require.Equal(t, []string{"1.blob", "2.blob", "3.blob", "4.blob"}, blobFiles(t, dir))
+
+	versions, err := db.History("key4")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.Equal(t, large+"4", string(versions[0].Value))
+}
+
+func TestMergeRemovesOrphanedBlobs(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 100)
+	db.SetBlobThreshold(16)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	large := strings.Repeat("v", 1000)
+	require.NoError(t, db.Set("key1", large+"1")) // 1.blob
+	require.NoError(t, db.Set("key2", large+"2")) // 2.blob
+
+	// The snapshot still reads the old values, so they outlive the merge
+	snap, err := db.Snapshot()
+	require.NoError(t, err)
+
+	require.NoError(t, db.Set("key1", large+"3")) // 3.blob
+	require.NoError(t, db.Delete("key2"))
+	require.NoError(t, db.Set("key3", large+"4")) // 4.blob, in the active file
+
+	// A blob without a record, as a crash before the append would leave
+	require.NoError(t, os.WriteFile(blobFilePath(dir, 100), []byte("orphan"), 0644))
+
+	require.NoError(t, db.Merge())
+	require.Equal(t, []string{"1.blob", "100.blob", "2.blob", "3.blob", "4.blob"}, blobFiles(t, dir))
+	val, ok, err := snap.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, large+"2", val)
+
+	snap.Release()
+	require.Equal(t, []string{"3.blob", "4.blob"}, blobFiles(t, dir))
+
+	val, ok, err = db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, large+"3", val)
+	require.Equal(t, large+"4", readAll(t, db, "key3"))
+}
+
+func TestGetReaderDetectsCorruptedBlobs(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	db.SetBlobThreshold(16)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", strings.Repeat("v", 100)))
+	require.NoError(t, os.WriteFile(blobFilePath(dir, 1), bytes.Repeat([]byte("x"), 100), 0644))
+
+	r, err := db.GetReader([]byte("key1"))
+	require.NoError(t, err)
+	defer func() { _ = r.Close() }()
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+
+	_, _, err = db.Get("key1")
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestBlobWritesThatDoNotHappen(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	db.SetBlobThreshold(16)
+	db.SetSizeLimits(0, 64)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	// The size limit covers blobs too
+	err := db.PutReader([]byte("key1"), strings.NewReader(strings.Repeat("v", 65)), 65)
+	require.ErrorIs(t, err, ErrValueTooLarge)
+
+	large := strings.Repeat("v", 64)
+	require.NoError(t, db.Set("key1", large))
+	swapped, err := db.CompareAndSwap("key1", "other", strings.Repeat("w", 64))
+	require.NoError(t, err)
+	require.False(t, swapped)
+
+	err = db.Update(func(tx *Txn) error {
+		_, _, err := tx.Get("key1")
+		require.NoError(t, err)
+		require.NoError(t, db.Set("key1", "changed"))
+		return tx.Set("key2", large)
+	})
+	require.ErrorIs(t, err, ErrConflict)
+
+	// Only the blob of the first write is left
+	require.Equal(t, []string{"1.blob"}, blobFiles(t, dir))
+
+	swapped, err = db.CompareAndSwap("key1", "changed", large)
+	require.NoError(t, err)
+	require.True(t, swapped)
+	require.Equal(t, large, readAll(t, db, "key1"))
+}
+
+func TestCompareAndSwapOnBlobValues(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	db.SetBlobThreshold(16)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	// Large enough to take more than one chunk of the comparison
+	large := strings.Repeat("v", 100<<10)
+	require.NoError(t, db.Set("key1", large))
+
+	// Same size, but the last byte differs
+	swapped, err := db.CompareAndSwap("key1", large[:len(large)-1]+"w", "new")
+	require.NoError(t, err)
+	require.False(t, swapped)
+
+	swapped, err = db.CompareAndSwap("key1", large[:len(large)-1], "new")
+	require.NoError(t, err)
+	require.False(t, swapped)
+
+	swapped, err = db.CompareAndSwap("key1", large, "new")
+	require.NoError(t, err)
+	require.True(t, swapped)
+	require.Equal(t, "new", readAll(t, db, "key1"))
+
+	require.NoError(t, db.Set("key2", large))
+	deleted, err := db.DeleteIfEquals("key2", large)
+	require.NoError(t, err)
+	require.True(t, deleted)
+	_, ok, err := db.Get("key2")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestValuesTooLargeForADataFileGoToBlobs(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 64<<10) // below the default blob threshold
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	// Between the file size and the blob threshold
+	medium := strings.Repeat("v", 100<<10)
+	require.NoError(t, db.Set("key1", medium))
+	require.NoError(t, db.PutReader([]byte("key2"), strings.NewReader(medium), int64(len(medium))))
+	require.NoError(t, db.Set("key3", strings.Repeat("v", 2<<20)))
+	require.Equal(t, []string{"1.blob", "2.blob", "3.blob"}, blobFiles(t, dir))
+
+	// Values that fit stay in the data file
+	require.NoError(t, db.Set("key4", strings.Repeat("v", 60<<10)))
+	require.Len(t, blobFiles(t, dir), 3)
+
+	require.Equal(t, medium, readAll(t, db, "key1"))
+	require.Equal(t, medium, readAll(t, db, "key2"))
+}
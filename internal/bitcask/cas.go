@@ -0,0 +1,176 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// CompareAndSwap sets key to new only if its current value is old. It
+// reports whether the value was swapped.
+func (db *Database) CompareAndSwap(key string, old string, new string) (bool, error) {
+	return db.writeIf([]byte(key), true, []byte(old), NewEntry(key, new))
+}
+
+// SetIfAbsent sets key to value only if the key does not exist or expired. It
+// reports whether the value was set.
+func (db *Database) SetIfAbsent(key string, value string) (bool, error) {
+	return db.writeIf([]byte(key), false, nil, NewEntry(key, value))
+}
+
+// DeleteIfEquals deletes key only if its current value is value. It reports
+// whether the key was deleted.
+func (db *Database) DeleteIfEquals(key string, value string) (bool, error) {
+	return db.writeIf([]byte(key), true, []byte(value), NewTombstone([]byte(key)))
+}
+
+// writeIf appends entry if key holds old, or if key is missing when exists is
+// false. The current value is compared under a read lock, and blob values are
+// streamed from their file without holding any lock. The entry is then
+// appended under the write lock only if the keydir entry of key is still the
+// one that was compared, so no other write can slip in between; otherwise the
+// comparison starts over.
+func (db *Database) writeIf(key []byte, exists bool, old []byte, entry *Entry) (bool, error) {
+	if err := db.checkEntry(entry); err != nil {
+		return false, err
+	}
+
+	entries, blobIDs, err := db.storeBlobs([]*Entry{entry})
+	if err != nil {
+		return false, err
+	}
+	defer db.releaseBlobs(blobIDs)
+
+	for {
+		current, err := db.compareValue(string(key), exists, old)
+		if err != nil {
+			return false, err
+		}
+
+		if !current.matches {
+			db.mu.Lock()
+			db.removeBlobs(blobIDs)
+			db.mu.Unlock()
+			return false, nil
+		}
+
+		appended, err := db.appendIfUnchanged(string(key), current, entries)
+		if err != nil || appended {
+			return appended, err
+		}
+	}
+}
+
+// comparedValue is the keydir entry of a key as writeIf compared it.
+type comparedValue struct {
+	meta    KeydirEntry
+	found   bool
+	matches bool
+}
+
+// compareValue compares the current value of key with old, or checks that key
+// is missing when exists is false. Blob values whose size or CRC differ from
+// old are rejected without reading the blob, and the others are compared as
+// they stream from the blob file once mu is released.
+func (db *Database) compareValue(key string, exists bool, old []byte) (comparedValue, error) {
+	current, blob, err := db.lookupCompared(key, exists, old)
+	if err != nil || blob == nil {
+		return current, err
+	}
+	defer func() { _ = blob.Close() }()
+
+	current.matches, err = readerEquals(blob, old)
+	return current, err
+}
+
+// lookupCompared does the part of compareValue that needs mu. It returns the
+// open blob file when the value still has to be compared with its content.
+func (db *Database) lookupCompared(key string, exists bool, old []byte) (comparedValue, *os.File, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.readOnly {
+		return comparedValue{}, nil, ErrReadOnly
+	}
+
+	meta, err := db.lookup(key, time.Now())
+	current := comparedValue{meta: meta, found: err == nil}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return current, nil, err
+	}
+	if !current.found || !exists {
+		current.matches = current.found == exists
+		return current, nil, nil
+	}
+
+	value, err := db.readRecordValue(meta)
+	if err != nil {
+		return current, nil, err
+	}
+	if !meta.Blob {
+		current.matches = bytes.Equal(value, old)
+		return current, nil, nil
+	}
+
+	ref, err := decodeBlobRef(value)
+	if err != nil {
+		return current, nil, err
+	}
+	if ref.size != uint64(len(old)) || ref.crc != crc32.ChecksumIEEE(old) {
+		return current, nil, nil
+	}
+
+	// The open file stays readable even if a merge removes the blob
+	f, err := os.Open(blobFilePath(db.dbPath, ref.id))
+	if err != nil {
+		return current, nil, fmt.Errorf("failed to open blob %d: %w", ref.id, err)
+	}
+
+	return current, f, nil
+}
+
+// appendIfUnchanged appends entries if key still has the keydir entry that was
+// compared, or is still missing. It reports whether they were appended.
+func (db *Database) appendIfUnchanged(key string, compared comparedValue, entries []*Entry) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	meta, err := db.lookup(key, time.Now())
+	found := err == nil
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	if found != compared.found || meta != compared.meta {
+		return false, nil
+	}
+
+	if err := db.appendEntries([][]*Entry{entries}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// readerEquals reports whether r holds exactly the bytes of value, reading it
+// in chunks.
+func readerEquals(r io.Reader, value []byte) (bool, error) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > len(value) || !bytes.Equal(buf[:n], value[:n]) {
+			return false, nil
+		}
+		value = value[n:]
+
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			return len(value) == 0, nil
+		case err != nil:
+			return false, fmt.Errorf("failed to read blob: %w", err)
+		}
+	}
+}
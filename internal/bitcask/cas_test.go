@@ -0,0 +1,145 @@
+package bitcask
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	swapped, err := db.CompareAndSwap("key1", "", "value1")
+	require.NoError(t, err)
+	require.False(t, swapped, "a missing key never matches")
+
+	require.NoError(t, db.Set("key1", "value1"))
+
+	swapped, err = db.CompareAndSwap("key1", "other", "value2")
+	require.NoError(t, err)
+	require.False(t, swapped)
+
+	swapped, err = db.CompareAndSwap("key1", "value1", "value2")
+	require.NoError(t, err)
+	require.True(t, swapped)
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value2", val)
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	set, err := db.SetIfAbsent("lease", "node1")
+	require.NoError(t, err)
+	require.True(t, set)
+
+	set, err = db.SetIfAbsent("lease", "node2")
+	require.NoError(t, err)
+	require.False(t, set)
+
+	val, _, err := db.Get("lease")
+	require.NoError(t, err)
+	require.Equal(t, "node1", val)
+
+	// An expired lease can be taken over
+	require.NoError(t, db.SetWithTTL("expiring", "node1", 50*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	set, err = db.SetIfAbsent("expiring", "node2")
+	require.NoError(t, err)
+	require.True(t, set)
+}
+
+func TestDeleteIfEquals(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+
+	require.NoError(t, db.Set("lease", "node1"))
+
+	deleted, err := db.DeleteIfEquals("lease", "node2")
+	require.NoError(t, err)
+	require.False(t, deleted)
+
+	deleted, err = db.DeleteIfEquals("lease", "node1")
+	require.NoError(t, err)
+	require.True(t, deleted)
+
+	deleted, err = db.DeleteIfEquals("lease", "node1")
+	require.NoError(t, err)
+	require.False(t, deleted)
+
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_, ok, err := db.Get("lease")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestConditionalWritesReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Close())
+
+	require.NoError(t, db.OpenReadOnly())
+	defer func() { _ = db.Close() }()
+
+	_, err := db.SetIfAbsent("key1", "value1")
+	require.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestConcurrentCompareAndSwapCounter(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 256)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("counter", "0"))
+
+	const workers = 8
+	const increments = 25
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				for {
+					val, _, err := db.Get("counter")
+					if !assert.NoError(t, err) {
+						return
+					}
+					n, _ := strconv.Atoi(val)
+					swapped, err := db.CompareAndSwap("counter", val, strconv.Itoa(n+1))
+					if !assert.NoError(t, err) {
+						return
+					}
+					if swapped {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, _, err := db.Get("counter")
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(workers*increments), val)
+}
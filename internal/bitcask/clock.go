@@ -0,0 +1,32 @@
+package bitcask
+
+import "time"
+
+// clock hands out write timestamps in nanoseconds. They follow the wall clock
+// but never repeat or go backwards: a write in the same nanosecond as the
+// last one, or after the wall clock was stepped back, gets the last timestamp
+// plus one.
+type clock struct {
+	now  func() time.Time
+	last uint64
+}
+
+// next returns a timestamp above every one handed out or observed so far. The
+// caller must hold mu exclusively.
+func (c *clock) next() uint64 {
+	ts := uint64(c.now().UnixNano())
+	if ts <= c.last {
+		ts = c.last + 1
+	}
+	c.last = ts
+	return ts
+}
+
+// observe records a timestamp read from the log, so writes after a restart
+// stay ahead of it even if the wall clock was stepped back meanwhile. The
+// caller must hold mu exclusively.
+func (c *clock) observe(ts uint64) {
+	if ts > c.last {
+		c.last = ts
+	}
+}
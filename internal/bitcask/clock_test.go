@@ -0,0 +1,48 @@
+package bitcask
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritesInTheSameNanosecondGetDistinctTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	setClock(db, time.Unix(1000, 0))
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Set("key2", "value2"))
+	require.NoError(t, db.Set("key1", "value3"))
+
+	require.Equal(t, uint64(1000*time.Second+1), db.keydir["key2"].Timestamp)
+	require.Equal(t, uint64(1000*time.Second+2), db.keydir["key1"].Timestamp)
+}
+
+func TestTimestampsSurviveClockStepBackAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+
+	now := time.Now()
+	setClock(db, now)
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Close())
+
+	// The wall clock went an hour back while the database was closed
+	db = NewDatabase(dir, 0)
+	setClock(db, now.Add(-time.Hour))
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value2"))
+	require.Greater(t, db.keydir["key1"].Timestamp, uint64(now.UnixNano()))
+
+	versions, err := db.History("key1")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, []byte("value2"), versions[1].Value)
+}
@@ -0,0 +1,181 @@
+package bitcask
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// These tests are meant to be run with -race.
+
+func TestConcurrentSetGetDeleteWithRotation(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 256) // small files to force frequent rotation
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	const workers = 8
+	const iterations = 200
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				key := fmt.Sprintf("w%d-key%d", w, i%10)
+				value := fmt.Sprintf("value%d", i)
+
+				if err := db.Set(key, value); err != nil {
+					errs <- err
+					return
+				}
+
+				// Every worker owns its keys, so it must read its own write
+				got, ok, err := db.Get(key)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !ok || got != value {
+					errs <- fmt.Errorf("key %s: expected %q, got %q (found=%v)", key, value, got, ok)
+					return
+				}
+
+				if i%7 == 0 {
+					if err := db.Delete(key); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.Greater(t, db.activeFileID, uint64(1))
+}
+
+func TestConcurrentReadersOnSharedKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 256)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+
+	for r := 0; r < 16; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				got, ok, err := db.Get(fmt.Sprintf("key%d", i))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if want := fmt.Sprintf("value%d", i); !ok || got != want {
+					errs <- fmt.Errorf("key%d: expected %q, got %q", i, want, got)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestConcurrentWritesDuringMerge(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 256)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	const workers = 4
+	const iterations = 150
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers+1)
+	done := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := db.Merge(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	var writers sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := 0; i < iterations; i++ {
+				key := fmt.Sprintf("w%d-key%d", w, i%5)
+				value := fmt.Sprintf("value%d", i)
+				if err := db.Set(key, value); err != nil {
+					errs <- err
+					return
+				}
+				got, ok, err := db.Get(key)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !ok || got != value {
+					errs <- fmt.Errorf("key %s: expected %q, got %q (found=%v)", key, value, got, ok)
+					return
+				}
+			}
+		}(w)
+	}
+
+	writers.Wait()
+	close(done)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// The final state survives a reopen
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+	for w := 0; w < workers; w++ {
+		for k := 0; k < 5; k++ {
+			key := fmt.Sprintf("w%d-key%d", w, k)
+			want := fmt.Sprintf("value%d", iterations-5+k)
+			got, ok, err := db.Get(key)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, want, got)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CorruptionPolicy decides what Open does with a record that fails its CRC
+// check, or whose sizes run past the end of the file while more valid records
+// follow it.
+type CorruptionPolicy int
+
+const (
+	// CorruptionSkip skips the bad record and resumes at the next record that
+	// passes its CRC check. This is the default.
+	CorruptionSkip CorruptionPolicy = iota
+
+	// CorruptionFail makes Open return a *CorruptionError.
+	CorruptionFail
+
+	// CorruptionQuarantine moves the whole data file into the corrupt/
+	// subdirectory and opens the database without it.
+	CorruptionQuarantine
+)
+
+const corruptDirName = "corrupt"
+
+// SetCorruptionPolicy chooses how Open handles corrupted records. It must be
+// called before Open.
+func (db *Database) SetCorruptionPolicy(policy CorruptionPolicy) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.corruptionPolicy = policy
+}
+
+// recoveryWindow is how much of a data file findNextValidEntry reads at a
+// time while it looks for the next valid record.
+const recoveryWindow = 64 << 10
+
+// knownFlags holds every flag a record can carry.
+const knownFlags = flagHasFlags | flagTombstone | flagExpires | batchMarkers | flagBlob
+
+// findNextValidEntry looks for the first offset in [from, size) where a
+// complete record passes its CRC check. It reads the file in windows of
+// recoveryWindow bytes and only computes the CRC of headers that could start
+// a record.
+func findNextValidEntry(f *os.File, from, size uint64) (uint64, bool, error) {
+	if from+headerSize > size {
+		return 0, false, nil
+	}
+
+	buf := make([]byte, min(recoveryWindow, size-from))
+	for start := from; start+headerSize <= size; {
+		window := buf[:min(uint64(len(buf)), size-start)]
+		if _, err := f.ReadAt(window, int64(start)); err != nil {
+			return 0, false, fmt.Errorf("failed to read %s: %w", f.Name(), err)
+		}
+
+		// Windows overlap by less than a header, so every offset whose
+		// header fits in the file is checked once
+		last := uint64(len(window)) - headerSize
+		for i := uint64(0); i <= last; i++ {
+			valid, err := isValidRecordAt(f, window, start, i, size)
+			if err != nil {
+				return 0, false, err
+			}
+			if valid {
+				return start + i, true, nil
+			}
+		}
+
+		start += last + 1
+	}
+
+	return 0, false, nil
+}
+
+// isValidRecordAt reports whether a complete record that passes its CRC check
+// starts at offset i of window, which holds the file from offset start. The
+// part of the record past the end of window is read from f.
+func isValidRecordAt(f *os.File, window []byte, start, i, size uint64) (bool, error) {
+	header := window[i : i+headerSize]
+	keySize, flags := splitKeySizeField(binary.LittleEndian.Uint32(header[keySizeOffset:]))
+	valueSize := binary.LittleEndian.Uint32(header[valueSizeOffset:])
+
+	// Records either carry flags or predate them, and only batch markers
+	// have no key
+	switch {
+	case flags != 0 && flags&flagHasFlags == 0, flags&^knownFlags != 0:
+		return false, nil
+	case keySize == 0 && flags&batchMarkers == 0:
+		return false, nil
+	}
+
+	payloadSize := uint64(keySize) + uint64(valueSize) + uint64(trailerSize(flags))
+	if start+i+headerSize+payloadSize > size {
+		return false, nil
+	}
+
+	payload := window[i+headerSize:]
+	if uint64(len(payload)) >= payloadSize {
+		checksum := crc32.ChecksumIEEE(header[crcEnd:])
+		checksum = crc32.Update(checksum, crc32.IEEETable, payload[:payloadSize])
+		return checksum == binary.LittleEndian.Uint32(header[crcOffset:]), nil
+	}
+
+	// The record runs past the window, so stream the rest of it
+	hash := crc32.NewIEEE()
+	_, _ = hash.Write(header[crcEnd:])
+	_, _ = hash.Write(payload)
+	rest := io.NewSectionReader(f, int64(start)+int64(len(window)), int64(payloadSize)-int64(len(payload)))
+	if _, err := io.Copy(hash, rest); err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", f.Name(), err)
+	}
+
+	return hash.Sum32() == binary.LittleEndian.Uint32(header[crcOffset:]), nil
+}
+
+// quarantineFile moves a corrupted data file into the corrupt/ subdirectory.
+// Read-only databases leave the file in place and just skip it.
+func (db *Database) quarantineFile(fileID uint64) error {
+	filePath := db.getDBFilePathByID(fileID)
+
+	if db.readOnly {
+		db.logger.Warn("skipping corrupted file", "file_id", fileID)
+		return nil
+	}
+
+	dir := filepath.Join(db.dbPath, corruptDirName)
+	if err := os.MkdirAll(dir, db.dirMode()); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	if err := removeIfExists(db.getHintFilePathByID(fileID)); err != nil {
+		return fmt.Errorf("failed to remove hint file with ID %d: %w", fileID, err)
+	}
+
+	if err := os.Rename(filePath, filepath.Join(dir, filepath.Base(filePath))); err != nil {
+		return fmt.Errorf("failed to quarantine file %s: %w", filePath, err)
+	}
+
+	db.logger.Warn("quarantined corrupted file", "file_id", fileID)
+	return nil
+}
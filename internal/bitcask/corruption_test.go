@@ -0,0 +1,281 @@
+package bitcask
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCorruptedDatabase writes key1, key2 and key3 (30 bytes each) to
+// data.1.cask and flips a byte inside the record of key2.
+func writeCorruptedDatabase(t *testing.T, flipAt int) string {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Set("key2", "value2"))
+	require.NoError(t, db.Set("key3", "value3"))
+	require.NoError(t, db.Close())
+
+	flipByte(t, filepath.Join(dir, "data.1.cask"), segmentHeaderSize+30+flipAt)
+
+	return dir
+}
+
+func flipByte(t *testing.T, path string, offset int) {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[offset] ^= 0xAA
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+func TestCorruptionFailPolicy(t *testing.T) {
+	dir := writeCorruptedDatabase(t, 25) // inside the value
+
+	db := NewDatabase(dir, 0)
+	db.SetCorruptionPolicy(CorruptionFail)
+	err := db.Open()
+
+	var corruptionErr *CorruptionError
+	require.ErrorAs(t, err, &corruptionErr)
+	require.Equal(t, uint64(1), corruptionErr.FileID)
+	require.Equal(t, uint64(segmentHeaderSize+30), corruptionErr.Offset)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+
+	// The failed open released the lock and left the file alone
+	info, statErr := os.Stat(filepath.Join(dir, "data.1.cask"))
+	require.NoError(t, statErr)
+	require.Equal(t, int64(segmentHeaderSize+90), info.Size())
+	require.NoError(t, NewDatabase(dir, 0).Open())
+}
+
+func TestCorruptionSkipPolicy(t *testing.T) {
+	for name, flipAt := range map[string]int{
+		"value byte":    25,
+		"key size byte": keySizeOffset + 2, // sizes now run past the end of the file
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeCorruptedDatabase(t, flipAt)
+
+			db := NewDatabase(dir, 0)
+			db.SetCorruptionPolicy(CorruptionSkip)
+			require.NoError(t, db.Open())
+			defer func() { _ = db.Close() }()
+
+			val, ok, err := db.Get("key1")
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, "value1", val)
+
+			_, ok, err = db.Get("key2")
+			require.NoError(t, err)
+			require.False(t, ok)
+
+			val, ok, err = db.Get("key3")
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, "value3", val)
+
+			// Skipping does not truncate data that comes after a bad record
+			require.Equal(t, uint64(0), db.DiscardedBytes())
+		})
+	}
+}
+
+func TestCorruptionSkipPolicyAtEndOfNewestFile(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Set("key2", "value2"))
+	require.NoError(t, db.Close())
+
+	flipByte(t, filepath.Join(dir, "data.1.cask"), segmentHeaderSize+55)
+
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	// Nothing valid follows the bad record, so it is cut off like a torn write
+	require.Equal(t, uint64(30), db.DiscardedBytes())
+	require.NoError(t, db.Set("key2", "value3"))
+
+	val, ok, err := db.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value3", val)
+}
+
+func TestZeroFilledTailOfNewestFileIsTorn(t *testing.T) {
+	for name, policy := range map[string]CorruptionPolicy{
+		"fail":       CorruptionFail,
+		"quarantine": CorruptionQuarantine,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			db := NewDatabase(dir, 0)
+			require.NoError(t, db.Open())
+			require.NoError(t, db.Set("key1", "value1"))
+			require.NoError(t, db.Close())
+
+			// A crash while the file grew can leave zeros instead of a record
+			path := filepath.Join(dir, "data.1.cask")
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			require.NoError(t, err)
+			_, err = f.Write(make([]byte, 64))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			db = NewDatabase(dir, 0)
+			db.SetCorruptionPolicy(policy)
+			require.NoError(t, db.Open())
+			defer func() { _ = db.Close() }()
+
+			require.Equal(t, uint64(64), db.DiscardedBytes())
+			require.Equal(t, uint64(1), db.activeFileID)
+
+			val, ok, err := db.Get("key1")
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, "value1", val)
+		})
+	}
+}
+
+func TestCorruptionQuarantinePolicy(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70) // 70 bytes
+	require.NoError(t, db.Open())
+	_ = db.Set("key1", "value1") // data.1
+	_ = db.Set("key2", "value2") // data.1
+	_ = db.Set("key3", "value3") // data.2
+	_ = db.Set("key1", "value4") // data.2
+	require.NoError(t, db.Close())
+
+	// Corrupt the newest file, which is scanned without a hint
+	flipByte(t, filepath.Join(dir, "data.2.cask"), segmentHeaderSize+25)
+
+	db = NewDatabase(dir, 70)
+	db.SetCorruptionPolicy(CorruptionQuarantine)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_, err := os.Stat(filepath.Join(dir, "data.2.cask"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, corruptDirName, "data.2.cask"))
+	require.NoError(t, err)
+
+	// Keys fall back to what the remaining files hold
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value1", val)
+
+	_, ok, err = db.Get("key3")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Writes go to a brand new active file
+	require.Equal(t, uint64(3), db.activeFileID)
+	require.NoError(t, db.Set("key3", "value5"))
+	val, ok, err = db.Get("key3")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value5", val)
+}
+
+func TestCorruptionQuarantineOfImmutableFile(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	_ = db.Set("key1", "value1") // data.1
+	_ = db.Set("key2", "value2") // data.1
+	_ = db.Set("key3", "value3") // data.2
+	require.NoError(t, db.Close())
+
+	// Without its hint the file is scanned and the corruption is found
+	require.NoError(t, os.Remove(filepath.Join(dir, "data.1.hint")))
+	flipByte(t, filepath.Join(dir, "data.1.cask"), segmentHeaderSize+25)
+
+	db = NewDatabase(dir, 70)
+	db.SetCorruptionPolicy(CorruptionQuarantine)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_, err := os.Stat(filepath.Join(dir, corruptDirName, "data.1.cask"))
+	require.NoError(t, err)
+
+	_, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	val, ok, err := db.Get("key3")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value3", val)
+	require.Equal(t, uint64(2), db.activeFileID)
+}
+
+func TestBadLastRecordOfImmutableFileIsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	_ = db.Set("key1", "value1") // data.1
+	_ = db.Set("key2", "value2") // data.1
+	_ = db.Set("key3", "value3") // data.2
+	require.NoError(t, db.Close())
+
+	// A value size running past the end of the file looks like a torn
+	// write, but data.1 was synced before data.2 was created
+	require.NoError(t, os.Remove(filepath.Join(dir, "data.1.hint")))
+	flipByte(t, filepath.Join(dir, "data.1.cask"), segmentHeaderSize+30+valueSizeOffset)
+
+	db = NewDatabase(dir, 70)
+	db.SetCorruptionPolicy(CorruptionFail)
+	var corruptionErr *CorruptionError
+	require.ErrorAs(t, db.Open(), &corruptionErr)
+	require.Equal(t, uint64(1), corruptionErr.FileID)
+	require.Equal(t, uint64(segmentHeaderSize+30), corruptionErr.Offset)
+
+	db = NewDatabase(dir, 70)
+	db.SetCorruptionPolicy(CorruptionQuarantine)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_, err := os.Stat(filepath.Join(dir, corruptDirName, "data.1.cask"))
+	require.NoError(t, err)
+	val, ok, err := db.Get("key3")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value3", val)
+}
+
+func TestFindNextValidEntryAcrossWindows(t *testing.T) {
+	record, err := NewEntry("key1", strings.Repeat("v", 100)).Encode()
+	require.NoError(t, err)
+
+	// Records that start in one window and end in the next, or start
+	// several windows in, are found where they are
+	for _, garbage := range []int{recoveryWindow - 50, 3*recoveryWindow + 7} {
+		path := filepath.Join(t.TempDir(), "data")
+		data := append(bytes.Repeat([]byte{0xFF}, garbage), record...)
+		require.NoError(t, os.WriteFile(path, data, 0644))
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+
+		offset, found, err := findNextValidEntry(f, 0, uint64(len(data)))
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, uint64(garbage), offset)
+
+		// A record cut short is not found
+		_, found, err = findNextValidEntry(f, 0, uint64(len(data)-1))
+		require.NoError(t, err)
+		require.False(t, found)
+		require.NoError(t, f.Close())
+	}
+}
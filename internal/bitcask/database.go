@@ -2,35 +2,106 @@ package bitcask
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const defaultMaxFileSize = 100 * 1024 * 1024 // 100 MB
+const (
+	defaultMaxFileSize = 100 * 1024 * 1024 // 100 MB
+	defaultFileMode    = 0644
+)
 
-var TombstoneValue = []byte{0xDE, 0xAD, 0xBE, 0xEF} // tombstone: non-ASCII/UTF-8 bytes, used to mark deletions
+// TombstoneValue marked deletions before records had flags. It is only used to
+// recognize deletions in records written in that format.
+var TombstoneValue = []byte{0xDE, 0xAD, 0xBE, 0xEF}
 
 type KeydirEntry struct {
 	FileID    uint64
 	ValuePos  uint64
 	ValueSize uint32
-	Timestamp uint64
+	Timestamp uint64 // Unix time in nanoseconds
+	ExpiresAt uint64 // Unix time in nanoseconds, zero if it never expires
+	Blob      bool   // the value is a reference to a blob file
+}
+
+// expired reports whether the entry has expired at now.
+func (e KeydirEntry) expired(now time.Time) bool {
+	return e.ExpiresAt != 0 && uint64(now.UnixNano()) >= e.ExpiresAt
 }
 
+// Database is safe for concurrent use. Reads share mu and run in parallel,
+// writes hold it exclusively so they are applied one at a time.
 type Database struct {
+	mu           sync.RWMutex
+	mergeMu      sync.Mutex // serializes merges, which mostly run without mu
 	maxFileSize  uint64
 	keydir       map[string]KeydirEntry
+	index        *indexNode // the keydir in key order
+	loading      bool       // the index is built once the keydir is loaded
 	dbPath       string
 	activeFile   *os.File
 	activeFileID uint64
 	files        map[uint64]*os.File
+	tombstones   map[string]KeydirEntry // tombstones in the active file
+
+	activeDataStart uint64 // offset of the first record in the active file
+	lockFile        *os.File
+	readOnly        bool
+
+	corruptionPolicy CorruptionPolicy
+	discardedBytes   uint64
+
+	groupCommit bool
+	commitMu    sync.Mutex
+	commitQueue []*commitRequest
+
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+	dirty        atomic.Bool // the active file has writes that were not synced
+	stopSyncer   chan struct{}
+	syncerWG     sync.WaitGroup
+
+	sweepInterval time.Duration
+	stopSweeper   chan struct{}
+	sweeperWG     sync.WaitGroup
+
+	retainVersions int           // older versions of each key merge keeps
+	retainAge      time.Duration // how long merge keeps replaced versions
+
+	clock clock // timestamps of new writes
+
+	maxKeySize   uint64      // largest key writes accept
+	maxValueSize uint64      // largest value writes accept
+	fileMode     os.FileMode // permissions of the files the database creates
+	logger       *slog.Logger
+	metrics      Metrics
+
+	mergeInterval time.Duration // how often to check whether to merge, zero to never
+	mergeMinFiles int           // immutable files that trigger a merge
+	lastMergedID  uint64        // newest file the last merge replaced, or wrote when reloaded
+	stopMerger    chan struct{}
+	mergerWG      sync.WaitGroup
+
+	blobThreshold uint64              // values larger than this go to blob files
+	nextBlobID    uint64              // ID of the next blob file
+	pendingBlobs  map[uint64]struct{} // blobs written but not yet referenced
+	orphanBlobs   map[uint64]struct{} // unreferenced blobs a pinned state may read
+
+	views    map[*view]struct{}    // open transactions
+	fileRefs map[*os.File]int      // pinned states holding each file
+	retired  map[*os.File]struct{} // files merge replaced that are still pinned
 }
 
 func NewDatabase(dbPath string, maxFileSize uint64) *Database {
@@ -38,15 +109,127 @@ func NewDatabase(dbPath string, maxFileSize uint64) *Database {
 		maxFileSize = defaultMaxFileSize
 	}
 
-	return &Database{
-		keydir:      make(map[string]KeydirEntry),
-		dbPath:      dbPath,
-		maxFileSize: maxFileSize,
-		files:       make(map[uint64]*os.File),
+	db := &Database{
+		keydir:        make(map[string]KeydirEntry),
+		dbPath:        dbPath,
+		maxFileSize:   maxFileSize,
+		files:         make(map[uint64]*os.File),
+		syncInterval:  defaultSyncInterval,
+		sweepInterval: defaultSweepInterval,
+		clock:         clock{now: time.Now},
+		maxKeySize:    MaxKeyLength,
+		maxValueSize:  math.MaxUint64,
+		fileMode:      defaultFileMode,
+		logger:        slog.Default(),
+		metrics:       noopMetrics{},
+		mergeMinFiles: defaultMergeMinFiles,
+		blobThreshold: defaultBlobThreshold,
+		pendingBlobs:  make(map[uint64]struct{}),
+		orphanBlobs:   make(map[uint64]struct{}),
+		views:         make(map[*view]struct{}),
+		fileRefs:      make(map[*os.File]int),
+		retired:       make(map[*os.File]struct{}),
+	}
+
+	return db
+}
+
+// SetSizeLimits chooses the largest key and value writes accept, in bytes.
+// Zero, or a key limit beyond what a record can hold, means the most a record
+// can hold: 16 MiB - 1 for keys. Values above the blob threshold are stored
+// in blob files, so by default they have no limit. It must be called before
+// Open.
+func (db *Database) SetSizeLimits(maxKeySize, maxValueSize uint64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if maxKeySize == 0 || maxKeySize > MaxKeyLength {
+		maxKeySize = MaxKeyLength
 	}
+	if maxValueSize == 0 {
+		maxValueSize = math.MaxUint64
+	}
+
+	db.maxKeySize = maxKeySize
+	db.maxValueSize = maxValueSize
+}
+
+// SetFileMode chooses the permissions of the data, hint and lock files the
+// database creates. Directories get the same permissions plus search access
+// wherever the file mode grants read access. Defaults to 0644 when zero. It
+// must be called before Open.
+func (db *Database) SetFileMode(mode os.FileMode) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if mode == 0 {
+		mode = defaultFileMode
+	}
+
+	db.fileMode = mode.Perm()
+}
+
+// dirMode returns the permissions of the directories the database creates.
+func (db *Database) dirMode() os.FileMode {
+	return db.fileMode | (db.fileMode&0444)>>2
 }
 
+// Open opens the database for reading and writing. It holds an exclusive lock
+// on the directory until Close, so no other process can open it meanwhile.
 func (db *Database) Open() error {
+	return db.open(false)
+}
+
+// OpenReadOnly opens the database without ever writing to it. It takes a
+// shared lock, so several read-only processes can open the same directory as
+// long as no process has it open for writing.
+func (db *Database) OpenReadOnly() error {
+	return db.open(true)
+}
+
+func (db *Database) open(readOnly bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !readOnly {
+		if err := os.MkdirAll(db.dbPath, db.dirMode()); err != nil {
+			return fmt.Errorf("failed to create database directory %s: %w", db.dbPath, err)
+		}
+	}
+
+	if err := db.acquireLock(!readOnly); err != nil {
+		return err
+	}
+
+	db.readOnly = readOnly
+	db.loading = true
+	err := db.load()
+	db.loading = false
+	if err != nil {
+		_ = db.releaseLock()
+		return err
+	}
+	db.index = indexBuild(db.keydir)
+
+	db.startSyncer()
+	db.startSweeper()
+	db.startMerger()
+
+	return nil
+}
+
+func (db *Database) load() error {
+	// Start from a clean keydir when reopening after Close
+	db.keydir = make(map[string]KeydirEntry)
+	db.index = nil
+	db.tombstones = make(map[string]KeydirEntry)
+
+	// A committed merge that was interrupted is finished before the segments
+	// are listed, since it replaces and removes some of them
+	if err := db.finishInterruptedMerge(); err != nil {
+		return err
+	}
+
 	files, err := filepath.Glob(filepath.Join(db.dbPath, "data.*.cask"))
 	if err != nil {
 		return fmt.Errorf("failed to list segment files: %w", err)
@@ -54,6 +237,21 @@ func (db *Database) Open() error {
 
 	fileIDs := parseSegmentFileIDs(files)
 
+	if db.readOnly {
+		return db.loadReadOnly(fileIDs)
+	}
+
+	// Leftovers from an uncommitted merge or hint write were never swapped in
+	if err := db.removeStaleMergeFiles(); err != nil {
+		return err
+	}
+	if err := db.removeStaleTmpFiles(); err != nil {
+		return err
+	}
+	if err := db.loadNextBlobID(); err != nil {
+		return err
+	}
+
 	// Create first DB file if none exists and return
 	if len(fileIDs) == 0 {
 		const activeFileID = 1
@@ -67,118 +265,490 @@ func (db *Database) Open() error {
 		return nil
 	}
 
-	// Load keydir from all files in order
-	for _, id := range fileIDs {
-		if err := db.loadKeydirFromFileID(id); err != nil {
-			return fmt.Errorf("failed to load keydir from file id %d: %w", id, err)
+	loadedIDs, err := db.loadKeydirs(fileIDs)
+	if err != nil {
+		return err
+	}
+
+	// Start a fresh active file if the newest one was quarantined, since the
+	// older files may already have hints that appending would invalidate.
+	// A newest file in an older format is left alone too, so records in the
+	// current format never land in it.
+	newestID := fileIDs[len(fileIDs)-1]
+	fresh := len(loadedIDs) == 0 || loadedIDs[len(loadedIDs)-1] != newestID
+
+	var header segmentHeader
+	if !fresh {
+		header, err = readSegmentHeaderByPath(db.getDBFilePathByID(newestID))
+		if err != nil {
+			return err
 		}
+		fresh = header.Version != segmentFormatVersion
 	}
 
-	// Set activeFile
-	if len(fileIDs) > 0 {
-		activeFileID := fileIDs[len(fileIDs)-1]
-		f, err := db.getDBFileByID(activeFileID)
+	if fresh {
+		db.tombstones = make(map[string]KeydirEntry)
+		activeFileID := newestID + 1
+		f, err := db.createNewDBFile(activeFileID)
 		if err != nil {
-			return fmt.Errorf("failed to open active db file: %w", err)
+			return err
 		}
 		db.activeFile = f
 		db.activeFileID = activeFileID
+		db.files[activeFileID] = f
+		return nil
+	}
+
+	// Set activeFile
+	f, err := db.getDBFileByID(newestID)
+	if err != nil {
+		return fmt.Errorf("failed to open active db file: %w", err)
+	}
+	db.activeFile = f
+	db.activeFileID = newestID
+	db.activeDataStart = header.DataStart()
+
+	return nil
+}
+
+// loadReadOnly builds the keydir without creating, repairing or cleaning up
+// any file. The newest segment stays readable but there is no active file.
+func (db *Database) loadReadOnly(fileIDs []uint64) error {
+	if len(fileIDs) == 0 {
+		return fmt.Errorf("no data files found in %s: %w", db.dbPath, os.ErrNotExist)
+	}
+
+	loadedIDs, err := db.loadKeydirs(fileIDs)
+	if err != nil {
+		return err
+	}
+	if len(loadedIDs) > 0 {
+		db.activeFileID = loadedIDs[len(loadedIDs)-1]
 	}
 
 	return nil
 }
 
+// loadKeydirs loads the keydir from all files in order and returns the IDs of
+// the files that were loaded, which excludes quarantined ones. The newest
+// file is still being appended to, so it never has a usable hint and it is
+// the only one that can end with a record torn by a crash.
+func (db *Database) loadKeydirs(fileIDs []uint64) ([]uint64, error) {
+	db.discardedBytes = 0
+	db.lastMergedID = 0
+
+	var loadedIDs []uint64
+	for i, id := range fileIDs {
+		// Only the tombstones of the newest file are kept
+		db.tombstones = make(map[string]KeydirEntry)
+
+		newest := i == len(fileIDs)-1
+		validSize, err := db.loadKeydir(id, newest)
+
+		var corruptionErr *CorruptionError
+		if errors.As(err, &corruptionErr) && db.corruptionPolicy == CorruptionQuarantine {
+			if err := db.quarantineFile(id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load keydir from file id %d: %w", id, err)
+		}
+
+		if err := db.discardTornTail(id, validSize, newest); err != nil {
+			return nil, err
+		}
+		loadedIDs = append(loadedIDs, id)
+	}
+
+	return loadedIDs, nil
+}
+
+// discardTornTail drops the bytes after the last complete record of a file.
+// Only the newest file is truncated, and only when the database is writable;
+// anywhere else the partial record is just skipped.
+func (db *Database) discardTornTail(fileID uint64, validSize uint64, newest bool) error {
+	filePath := db.getDBFilePathByID(fileID)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file %s: %w", filePath, err)
+	}
+
+	size := uint64(info.Size())
+	if size <= validSize {
+		return nil
+	}
+
+	if !newest || db.readOnly {
+		db.logger.Warn("ignoring trailing bytes of incomplete record", "file_id", fileID, "bytes", size-validSize)
+		return nil
+	}
+
+	if err := os.Truncate(filePath, int64(validSize)); err != nil {
+		return fmt.Errorf("failed to truncate torn write in file %s: %w", filePath, err)
+	}
+
+	db.discardedBytes = size - validSize
+	db.logger.Warn("discarded incomplete record at the end of file", "file_id", fileID, "bytes", db.discardedBytes)
+
+	return nil
+}
+
+// DiscardedBytes returns how many bytes of an incomplete trailing record the
+// last Open truncated from the newest data file.
+func (db *Database) DiscardedBytes() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.discardedBytes
+}
+
 func (db *Database) Close() error {
-	for _, f := range db.files {
+	db.stopBackgroundMerger()
+	db.stopBackgroundSyncer()
+	db.stopBackgroundSweeper()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.activeFile != nil && !db.readOnly {
+		if err := db.syncActiveFile(); err != nil {
+			return err
+		}
+		if err := syncDir(db.dbPath); err != nil {
+			return err
+		}
+	}
+
+	// After Open the active file has its own append handle
+	if db.activeFile != nil && db.activeFile != db.files[db.activeFileID] {
+		if err := db.activeFile.Close(); err != nil {
+			return err
+		}
+	}
+	db.activeFile = nil
+
+	for id, f := range db.files {
 		if err := f.Close(); err != nil {
 			return err
 		}
+		delete(db.files, id)
 	}
-	return nil
+
+	for f := range db.retired {
+		if err := f.Close(); err != nil {
+			return err
+		}
+		delete(db.retired, f)
+	}
+
+	return db.releaseLock()
 }
 
 func (db *Database) Get(key string) (string, bool, error) {
+	value, exists, err := db.GetBytes([]byte(key))
+	if err != nil || !exists {
+		return "", false, err
+	}
+
+	return string(value), true, nil
+}
+
+// GetBytes returns the value of key as read from the data file, without any
+// conversion.
+func (db *Database) GetBytes(key []byte) ([]byte, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	meta, err := db.lookup(string(key), time.Now())
+	if errors.Is(err, ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, err := db.readValue(meta)
+	if err != nil {
+		return nil, false, err
+	}
+	db.metrics.Count(MetricReads, 1)
+
+	return value, true, nil
+}
+
+// lookup returns the keydir entry of key, or ErrNotFound when it is missing or
+// expired at now. The caller must hold mu.
+func (db *Database) lookup(key string, now time.Time) (KeydirEntry, error) {
 	if len(db.files) == 0 {
-		return "", false, fmt.Errorf("the database is not fully initialized: there are not db files")
+		return KeydirEntry{}, ErrClosed
 	}
 
 	meta, exists := db.keydir[key]
-	if !exists {
-		return "", false, nil
+	if !exists || meta.expired(now) {
+		return KeydirEntry{}, ErrNotFound
 	}
 
-	// Seek to value position from specific file
-	if _, err := db.files[meta.FileID].Seek(int64(meta.ValuePos), io.SeekStart); err != nil {
-		return "", false, fmt.Errorf("failed to seek to value: %w", err)
+	return meta, nil
+}
+
+func (db *Database) Set(key string, value string) error {
+	return db.Put([]byte(key), []byte(value))
+}
+
+// Put stores value under key. Both slices are written as they are, so they
+// must not be modified until Put returns.
+func (db *Database) Put(key []byte, value []byte) error {
+	return db.write(NewEntryBytes(key, value))
+}
+
+// write appends the entries as one unit: they all land in the same file and
+// several entries are framed as a batch. The caller must not hold mu.
+func (db *Database) write(entries ...*Entry) error {
+	// A bad entry must not fail the writes grouped with it
+	for _, entry := range entries {
+		if err := db.checkEntry(entry); err != nil {
+			return err
+		}
 	}
 
-	// Read value
-	value := make([]byte, meta.ValueSize)
-	if _, err := db.files[meta.FileID].Read(value); err != nil {
-		return "", false, fmt.Errorf("failed to read value: %w", err)
+	entries, blobIDs, err := db.storeBlobs(entries)
+	if err != nil {
+		return err
 	}
+	defer db.releaseBlobs(blobIDs)
 
-	if bytes.Equal(value, TombstoneValue) {
-		return "", false, nil
+	if db.groupCommit {
+		return db.commitGrouped(entries)
 	}
 
-	return string(value), true, nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.appendEntries([][]*Entry{entries})
 }
 
-func (db *Database) Set(key string, value string) error {
-	if db.activeFile == nil {
-		return fmt.Errorf("the database is not fully initialized: there is not an active file")
+// checkEntry rejects an entry that does not fit in a record, breaks the size
+// limits or would not fit in a data file on its own, so the write fails before
+// anything reaches the file. Values bound for a blob file are checked as the
+// reference the record will hold. Batches are checked entry by entry and may
+// still make a file grow past its maximum size, since they never span two
+// files.
+func (db *Database) checkEntry(e *Entry) error {
+	valueSize := uint64(e.ValueSize())
+	if e.blob {
+		ref, err := decodeBlobRef(e.Value)
+		if err != nil {
+			return err
+		}
+		valueSize = ref.size
+	}
+	if db.storesBlob(e) {
+		e = e.withBlob(blobRef{size: valueSize})
 	}
 
-	entry := NewEntry(key, value)
+	if err := e.Validate(); err != nil {
+		return err
+	}
+
+	switch {
+	case uint64(e.KeySize()) > db.maxKeySize:
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrKeyTooLarge, e.KeySize(), db.maxKeySize)
+	case valueSize > db.maxValueSize:
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrValueTooLarge, valueSize, db.maxValueSize)
+	case e.Size()-uint64(e.ValueSize()) > db.maxFileSize:
+		// The key does not fit even with an empty value
+		return fmt.Errorf("%w: its record of %d bytes exceeds the maximum file size of %d bytes", ErrKeyTooLarge, e.Size(), db.maxFileSize)
+	case e.Size() > db.maxFileSize:
+		return fmt.Errorf("%w: its record of %d bytes exceeds the maximum file size of %d bytes", ErrValueTooLarge, e.Size(), db.maxFileSize)
+	}
+
+	return nil
+}
+
+// appendEntries appends groups of entries to the active file with as few
+// writes as possible, rotating whenever the next group would not fit, and
+// then updates the keydir. A group never spans two files, and groups of more
+// than one entry are written between batch markers so Open applies all of
+// them or none. The active file is synced under SyncAlways and with group
+// commit, whose writers are released only once their records are durable.
+// The caller must hold mu.
+func (db *Database) appendEntries(groups [][]*Entry) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	if db.activeFile == nil {
+		return ErrClosed
+	}
 
 	// Calculate value position
 	fileOffset, err := db.activeFile.Seek(0, io.SeekEnd) // current end of file
 	if err != nil {
 		return fmt.Errorf("failed to seek database file: %w", err)
 	}
-	valuePos := fileOffset + entry.ValueOffset()
 
-	// Encode
-	data, err := entry.Encode()
-	if err != nil {
-		return fmt.Errorf("failed to encode entry: %w", err)
+	var buf []byte
+	var written []hintEntry
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		if _, err := db.activeFile.Write(buf); err != nil {
+			return fmt.Errorf("failed to write entry: %w", err)
+		}
+		db.dirty.Store(true)
+		db.metrics.Count(MetricWrites, int64(len(written)))
+		db.metrics.Count(MetricBytesWritten, int64(len(buf)))
+		for _, w := range written {
+			db.applyHint(w)
+		}
+		buf, written = buf[:0], written[:0]
+		return nil
 	}
 
-	// Check if adding this entry would exceed maxFileSize
-	if uint64(fileOffset)+uint64(len(data)) > db.maxFileSize {
-		// rotate to a new file (or handle however you want)
-		if err := db.rotateActiveFile(); err != nil {
-			return fmt.Errorf("failed to rotate file: %w", err)
+	for _, group := range groups {
+		for _, entry := range group {
+			entry.Timestamp = db.clock.next()
+		}
+
+		// Encode
+		data, starts, err := encodeGroup(group)
+		if err != nil {
+			return fmt.Errorf("failed to encode entry: %w", err)
+		}
+
+		// Check if adding this group would exceed maxFileSize. The limit
+		// applies to the records, not to the file header.
+		if uint64(fileOffset)-db.activeDataStart+uint64(len(data)) > db.maxFileSize {
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := db.rotateActiveFile(); err != nil {
+				return fmt.Errorf("failed to rotate file: %w", err)
+			}
+			fileOffset = int64(db.activeDataStart)
+		}
+
+		buf = append(buf, data...)
+		for i, entry := range group {
+			written = append(written, hintEntry{
+				key:       string(entry.Key),
+				tombstone: entry.Tombstone,
+				meta: KeydirEntry{
+					FileID:    db.activeFileID,
+					ValuePos:  uint64(fileOffset + starts[i] + entry.ValueOffset()),
+					ValueSize: uint32(len(entry.Value)),
+					Timestamp: entry.Timestamp,
+					ExpiresAt: entry.ExpiresAt,
+					Blob:      entry.blob,
+				},
+			})
 		}
-		valuePos = entry.ValueOffset()
+		fileOffset += int64(len(data))
 	}
 
-	if _, err := db.activeFile.Write(data); err != nil {
-		return fmt.Errorf("failed to write entry: %w", err)
+	if err := flush(); err != nil {
+		return err
 	}
 
-	// Update keydir
-	db.keydir[key] = KeydirEntry{
-		FileID:    db.activeFileID,
-		ValuePos:  uint64(valuePos),
-		ValueSize: uint32(len(entry.Value)),
-		Timestamp: entry.Timestamp,
+	if db.syncPolicy == SyncAlways || db.groupCommit {
+		return db.syncActiveFile()
 	}
 
 	return nil
 }
 
+// encodeGroup encodes a group of entries, framing it with batch markers when
+// it holds more than one entry. It also returns where each entry starts in
+// the encoded bytes.
+func encodeGroup(group []*Entry) ([]byte, []int64, error) {
+	records := group
+	if len(group) > 1 {
+		records = make([]*Entry, 0, len(group)+2)
+		records = append(records, newBatchMarker(flagBatchBegin, len(group)))
+		records = append(records, group...)
+		records = append(records, newBatchMarker(flagBatchCommit, len(group)))
+	}
+
+	var data []byte
+	starts := make([]int64, 0, len(group))
+	for _, record := range records {
+		encoded, err := record.Encode()
+		if err != nil {
+			return nil, nil, err
+		}
+		if record.marker == 0 {
+			starts = append(starts, int64(len(data)))
+		}
+		data = append(data, encoded...)
+	}
+
+	return data, starts, nil
+}
+
+// Delete appends a tombstone for key and drops it from the keydir.
 func (db *Database) Delete(key string) error {
-	return db.Set(key, string(TombstoneValue))
+	return db.write(NewTombstone([]byte(key)))
+}
+
+// applyHint applies an entry read from a file or just written to the keydir.
+// Tombstones remove their key and are remembered until the file they live in
+// gets its hint, which must carry them so older values stay deleted. Expired
+// entries are handled the same way. The caller must hold mu.
+func (db *Database) applyHint(h hintEntry) {
+	db.recordChange(h.key)
+	db.clock.observe(h.meta.Timestamp)
+
+	if h.tombstone || h.meta.expired(time.Now()) {
+		db.deleteKeydir(h.key)
+		db.tombstones[h.key] = h.meta
+		return
+	}
+
+	db.setKeydir(h.key, h.meta)
+}
+
+// readValue reads a value with a positional read, so concurrent readers never
+// share a file offset, following blob references to their blob file. The
+// caller must hold mu.
+func (db *Database) readValue(meta KeydirEntry) ([]byte, error) {
+	value, err := db.readRecordValue(meta)
+	if err != nil || !meta.Blob {
+		return value, err
+	}
+
+	return readBlob(db.dbPath, value)
+}
+
+// readRecordValue reads the value as the record holds it, which for blobs is
+// the reference. The caller must hold mu.
+func (db *Database) readRecordValue(meta KeydirEntry) ([]byte, error) {
+	f, ok := db.files[meta.FileID]
+	if !ok {
+		return nil, fmt.Errorf("no open db file with ID %d", meta.FileID)
+	}
+
+	value := make([]byte, meta.ValueSize)
+	if _, err := f.ReadAt(value, int64(meta.ValuePos)); err != nil {
+		return nil, fmt.Errorf("failed to read value: %w", err)
+	}
+
+	return value, nil
 }
 
 func (db *Database) createNewDBFile(fileID uint64) (*os.File, error) {
-	filePath := filepath.Join(db.dbPath, fmt.Sprintf("data.%d.cask", fileID))
-	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	filePath := db.getDBFilePathByID(fileID)
+	if err := createSegmentFile(filePath, db.fileMode); err != nil {
+		return nil, fmt.Errorf("failed to create file with ID %d: %w", fileID, err)
+	}
+
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_RDWR, db.fileMode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file with ID %d: %w", fileID, err)
 	}
+	db.activeDataStart = segmentHeaderSize
 	return f, nil
 }
 
@@ -192,57 +762,155 @@ func (db *Database) rotateActiveFile() error {
 
 	newActiveFileID := uint64(activeFileID + 1)
 
+	// The file becomes immutable, so whatever the sync policy it must be
+	// fully on disk before writes move on
+	if err := db.syncActiveFile(); err != nil {
+		return err
+	}
+
 	f, err := db.createNewDBFile(newActiveFileID)
 
 	if err != nil {
 		return fmt.Errorf("failed to rotate db file: %w", err)
 	}
 
+	if err := syncDir(db.dbPath); err != nil {
+		_ = f.Close()
+		return err
+	}
+
 	db.activeFile = f
 	db.activeFileID = newActiveFileID
 	db.files[newActiveFileID] = f
 
+	// The previous file is immutable from now on. The hint only speeds up
+	// Open, so failing to write it must not fail the write that rotated.
+	if err := db.writeHintForFileID(activeFileID); err != nil {
+		db.logger.Warn("failed to write hint file", "file_id", activeFileID, "error", err)
+	}
+	db.tombstones = make(map[string]KeydirEntry)
+
 	return nil
 }
 
-func (db *Database) loadKeydirFromFileID(fileID uint64) error {
+// loadKeydirFromFileID scans a data file and returns the size of its valid
+// prefix, which stops short of the file size when the last record or batch is
+// incomplete. Batches are only applied once their commit marker is read, so
+// one cut short by a crash is rolled back. Only the newest file can have a
+// torn tail, since the others were synced before writes moved on: there,
+// bad bytes that no valid record follows are dropped, since a crash while the
+// file grew can leave anything there. Other corrupted records, including a
+// bad last record of an older file, are handled according to the corruption
+// policy, and nothing is added to the keydir when the scan fails.
+func (db *Database) loadKeydirFromFileID(fileID uint64, header segmentHeader, newest bool) (uint64, error) {
 	filePath := db.getDBFilePathByID(fileID)
 	f, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %w", filePath, err)
+		return 0, fmt.Errorf("failed to open file %s: %w", filePath, err)
 	}
 
-	db.files[fileID] = f
-
-	var offset uint64 = 0
-
-	if _, err := f.Seek(0, io.SeekStart); err != nil {
-		return err
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return 0, fmt.Errorf("failed to stat file %s: %w", filePath, err)
 	}
+	size := uint64(info.Size())
 
+	var entries []hintEntry
+	var batch *pendingBatch
+	offset := header.DataStart()
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		_ = f.Close()
+		return 0, err
+	}
 	reader := bufio.NewReader(f)
 
 	for {
-		decodedEntry, err := decodeNextEntry(reader)
+		decodedEntry, payload, err := decodeNextRecord(reader, size-offset)
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			fmt.Fprintf(os.Stderr, "warning: failed to decode entry at offset %d: %v\n", offset, err)
-			// skip the corrupted entry by advancing the offset
-			offset += uint64(decodedEntry.EntrySize)
+			next, found, syncErr := findNextValidEntry(f, offset+1, size)
+			if syncErr != nil {
+				_ = f.Close()
+				return 0, syncErr
+			}
+
+			// The newest file ends in the middle of a record, or in bytes a
+			// crash left behind, like a zero-filled tail
+			if !found && newest {
+				break
+			}
+
+			if db.corruptionPolicy != CorruptionSkip {
+				_ = f.Close()
+				return 0, &CorruptionError{FileID: fileID, Offset: offset, Err: err}
+			}
+
+			db.logger.Warn("skipping corrupted entry", "file_id", fileID, "offset", offset, "error", err)
+			if batch != nil {
+				// The commit count no longer matches, so the batch is dropped
+				batch.damaged = true
+			}
+			if !found {
+				break
+			}
+
+			// Resynchronize on the next record that passes its CRC check
+			offset = next
+			if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+				_ = f.Close()
+				return 0, err
+			}
+			reader.Reset(f)
 			continue
 		}
 
-		db.keydir[decodedEntry.Key] = db.buildKeydirEntry(offset, decodedEntry, fileID)
+		entry := hintEntry{
+			key:       decodedEntry.Key,
+			tombstone: decodedEntry.Tombstone,
+			meta:      db.buildKeydirEntry(header, offset, decodedEntry, fileID),
+		}
+
+		switch {
+		case decodedEntry.Marker == flagBatchBegin:
+			if batch != nil {
+				db.logger.Warn("rolling back uncommitted batch", "file_id", fileID, "offset", batch.offset)
+			}
+			batch = &pendingBatch{offset: offset}
+		case decodedEntry.Marker == flagBatchCommit:
+			count := binary.LittleEndian.Uint32(payload[decodedEntry.KeySize:])
+			if batch != nil && batch.complete(count) {
+				entries = append(entries, batch.entries...)
+			} else {
+				db.logger.Warn("dropping incomplete batch", "file_id", fileID, "offset", offset)
+			}
+			batch = nil
+		case batch != nil:
+			batch.entries = append(batch.entries, entry)
+		default:
+			entries = append(entries, entry)
+		}
 		offset += uint64(decodedEntry.EntrySize)
 	}
 
-	return nil
+	// A batch without its commit marker was cut short, so its records are
+	// left out of the valid prefix
+	if batch != nil {
+		offset = batch.offset
+	}
+
+	db.files[fileID] = f
+	for _, e := range entries {
+		db.applyHint(e)
+	}
+
+	return offset, nil
 }
 
 func (db *Database) getDBFileByID(id uint64) (*os.File, error) {
 	filePath := db.getDBFilePathByID(id)
-	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, db.fileMode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
 	}
@@ -254,39 +922,53 @@ func (db *Database) getDBFilePathByID(id uint64) string {
 	return filepath.Join(db.dbPath, fmt.Sprintf("data.%d.cask", id))
 }
 
-func (db *Database) buildKeydirEntry(entryOffset uint64, decodedEntry *DecodedEntry, fileID uint64) KeydirEntry {
+func (db *Database) buildKeydirEntry(header segmentHeader, entryOffset uint64, decodedEntry *DecodedEntry, fileID uint64) KeydirEntry {
 	return KeydirEntry{
 		FileID:    fileID,
 		ValuePos:  entryOffset + headerSize + uint64(decodedEntry.KeySize),
 		ValueSize: decodedEntry.ValueSize,
-		Timestamp: decodedEntry.Timestamp,
+		Timestamp: header.Timestamp(decodedEntry.Timestamp),
+		ExpiresAt: decodedEntry.ExpiresAt,
+		Blob:      decodedEntry.Blob,
 	}
 }
 
-func decodeNextEntry(r io.Reader) (*DecodedEntry, error) {
+// decodeNextRecord reads the next record from r, which has remaining bytes
+// left, and returns it along with the bytes that follow its header, starting
+// with the key and the value. A record that does not fit in them is reported
+// as io.ErrUnexpectedEOF before anything is allocated for it.
+func decodeNextRecord(r io.Reader, remaining uint64) (*DecodedEntry, []byte, error) {
 	// 1. Read the fixed-size header
 	headerBuf := make([]byte, headerSize)
 	if _, err := io.ReadFull(r, headerBuf); err != nil {
-		return nil, err // Can be io.EOF
+		return nil, nil, err // Can be io.EOF
 	}
 
 	// 2. Extract sizes from the header to know how much more to read
-	keySize := binary.LittleEndian.Uint32(headerBuf[keySizeOffset:])
+	keySize, flags := splitKeySizeField(binary.LittleEndian.Uint32(headerBuf[keySizeOffset:]))
 	valueSize := binary.LittleEndian.Uint32(headerBuf[valueSizeOffset:])
+	payloadSize := uint64(keySize) + uint64(valueSize) + uint64(trailerSize(flags))
+	if headerSize+payloadSize > remaining {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
 
-	// 3. Read the variable-sized key and value
-	kvBuf := make([]byte, keySize+valueSize)
+	// 3. Read the variable-sized key, value and trailer
+	kvBuf := make([]byte, payloadSize)
 	if _, err := io.ReadFull(r, kvBuf); err != nil {
-		return nil, err
+		if err == io.EOF {
+			// A complete header without its key and value is a torn record
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
 	}
 
 	decodedEntry, err := Decode(headerBuf, kvBuf, keySize, valueSize)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return decodedEntry, nil
+	return decodedEntry, kvBuf, nil
 }
 
 func parseSegmentFileIDs(files []string) []uint64 {
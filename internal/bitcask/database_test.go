@@ -1,6 +1,7 @@
 package bitcask
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -176,10 +177,10 @@ func TestFileRotationOnMaxSize(t *testing.T) {
 
 	// Check sizes
 	file1Info, _ := db.files[1].Stat()
-	require.Equal(t, int64(60), file1Info.Size())
+	require.Equal(t, int64(segmentHeaderSize+60), file1Info.Size())
 
 	file2Info, _ := db.files[2].Stat()
-	require.Equal(t, int64(30), file2Info.Size())
+	require.Equal(t, int64(segmentHeaderSize+30), file2Info.Size())
 }
 
 func TestGetValuesAcrossFiles(t *testing.T) {
@@ -295,3 +296,127 @@ func TestDeletetionPersistenceAcrossFiles(t *testing.T) {
 
 	_ = db.Close()
 }
+
+func TestOpenTruncatesTornWrite(t *testing.T) {
+	torn, _ := NewEntry("key2", "value2").Encode() // 30 bytes
+
+	for name, tail := range map[string][]byte{
+		"partial header":    torn[:10],
+		"header only":       torn[:headerSize],
+		"partial key/value": torn[:len(torn)-3],
+		"missing last byte": torn[:len(torn)-1],
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			db := NewDatabase(dir, 0)
+			require.NoError(t, db.Open())
+			require.NoError(t, db.Set("key1", "value1")) // 30 bytes
+			require.NoError(t, db.Close())
+
+			// Simulate a crash in the middle of appending a record
+			path := filepath.Join(dir, "data.1.cask")
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			require.NoError(t, err)
+			_, err = f.Write(tail)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			require.NoError(t, db.Open())
+			defer func() { _ = db.Close() }()
+
+			require.Equal(t, uint64(len(tail)), db.DiscardedBytes())
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			require.Equal(t, int64(segmentHeaderSize+30), info.Size())
+
+			val, ok, err := db.Get("key1")
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, "value1", val)
+
+			_, ok, err = db.Get("key2")
+			require.NoError(t, err)
+			require.False(t, ok)
+
+			// New writes land right after the last valid record
+			require.NoError(t, db.Set("key3", "value3"))
+			require.NoError(t, db.Close())
+			require.NoError(t, db.Open())
+
+			require.Equal(t, uint64(0), db.DiscardedBytes())
+			val, ok, err = db.Get("key3")
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, "value3", val)
+		})
+	}
+}
+
+func TestOpenReadOnlyDoesNotTruncateTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Close())
+
+	path := filepath.Join(dir, "data.1.cask")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, db.OpenReadOnly())
+	defer func() { _ = db.Close() }()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(segmentHeaderSize+33), info.Size())
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value1", val)
+}
+
+func TestDatabasePutAndGetBytes(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+
+	key := []byte{0x00, 0xFF, 'k', 0x80}
+	value := []byte{0x00, 0x01, 0xFE, 0xFF, 0x00}
+
+	require.NoError(t, db.Put(key, value))
+	require.NoError(t, db.Put([]byte("empty"), []byte{}))
+
+	got, ok, err := db.GetBytes(key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, value, got)
+
+	// Reopen and check binary data survives the log
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	got, ok, err = db.GetBytes(key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, value, got)
+
+	got, ok, err = db.GetBytes([]byte("empty"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Empty(t, got)
+
+	// The string API sees the same data
+	str, ok, err := db.Get(string(key))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, string(value), str)
+
+	_, ok, err = db.GetBytes([]byte("missing"))
+	require.NoError(t, err)
+	require.False(t, ok)
+}
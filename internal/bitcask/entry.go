@@ -1,9 +1,11 @@
 package bitcask
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
+	"math"
 	"time"
 )
 
@@ -12,6 +14,7 @@ const (
 	timestampSize = 8 // 64 bits for timestamp
 	keySizeSize   = 4 // 32 bits for key size
 	valueSizeSize = 4 // 32 bits for value size
+	expiresAtSize = 8 // 64 bits for the expiry time of expiring records
 )
 
 const metadataSize = timestampSize + keySizeSize + valueSizeSize
@@ -27,10 +30,45 @@ const valueSizeOffset = crcSize + timestampSize + keySizeSize
 const valueSizeEnd = valueSizeOffset + valueSizeSize
 const keyOffset = crcSize + timestampSize + keySizeSize + valueSizeSize
 
+// The high byte of the key size field holds the record flags, leaving 24 bits
+// for the key size. Records written before flags existed always have it zero,
+// since their keys were never that large.
+const (
+	keySizeMask = 0x00FFFFFF
+	flagsShift  = 24
+)
+
+// MaxKeyLength and MaxValueLength are the largest key and value a record can
+// hold, in bytes.
+const (
+	MaxKeyLength   = keySizeMask
+	MaxValueLength = math.MaxUint32
+)
+
+const (
+	flagHasFlags  = 0x01 // set on every record that carries flags
+	flagTombstone = 0x02 // the record marks its key as deleted
+	flagExpires   = 0x04 // the value is followed by the time the record expires
+
+	// Batch markers frame the records of a batch. Their value holds the
+	// number of records in the batch.
+	flagBatchBegin  = 0x08
+	flagBatchCommit = 0x10
+	batchMarkers    = flagBatchBegin | flagBatchCommit
+
+	// The value is a reference to a blob file holding the real value
+	flagBlob = 0x20
+)
+
 type Entry struct {
-	Timestamp uint64
-	Key       string
-	Value     string
+	Timestamp uint64 // Unix time in nanoseconds, set again when it is written
+	Key       []byte
+	Value     []byte
+	Tombstone bool
+	ExpiresAt uint64 // Unix time in nanoseconds, zero if it never expires
+
+	marker byte // flagBatchBegin or flagBatchCommit for batch markers
+	blob   bool // Value is an encoded blobRef
 }
 
 type DecodedEntry struct {
@@ -40,30 +78,95 @@ type DecodedEntry struct {
 	ValueSize   uint32
 	EntrySize   uint32
 	ValueOffset uint32
+	Tombstone   bool
+	ExpiresAt   uint64
+	Marker      byte // flagBatchBegin or flagBatchCommit for batch markers
+	Blob        bool // the value is a reference to a blob file
 }
 
 // NewEntry creates a new entry with the current timestamp.
 func NewEntry(key string, value string) *Entry {
+	return NewEntryBytes([]byte(key), []byte(value))
+}
+
+// NewEntryBytes creates a new entry with the current timestamp. The entry
+// keeps the given slices, so they must not be modified until it is encoded.
+func NewEntryBytes(key []byte, value []byte) *Entry {
+	return &Entry{
+		Timestamp: uint64(time.Now().UnixNano()),
+		Key:       key,
+		Value:     value,
+	}
+}
+
+// NewTombstone creates an entry that marks key as deleted.
+func NewTombstone(key []byte) *Entry {
 	return &Entry{
-		Timestamp: uint64(time.Now().Unix()),
+		Timestamp: uint64(time.Now().UnixNano()),
 		Key:       key,
+		Tombstone: true,
+	}
+}
+
+// newBatchMarker creates the begin or commit marker of a batch of count
+// records.
+func newBatchMarker(marker byte, count int) *Entry {
+	value := make([]byte, 4)
+	binary.LittleEndian.PutUint32(value, uint32(count))
+
+	return &Entry{
+		Timestamp: uint64(time.Now().UnixNano()),
 		Value:     value,
+		marker:    marker,
+	}
+}
+
+// Validate checks that the entry fits in a record. Batch markers are the only
+// records without a key.
+func (e *Entry) Validate() error {
+	switch {
+	case e.KeySize() == 0 && e.marker == 0:
+		return ErrEmptyKey
+	case e.KeySize() > MaxKeyLength:
+		return fmt.Errorf("%w: %d bytes exceeds the maximum of %d bytes", ErrKeyTooLarge, e.KeySize(), MaxKeyLength)
+	case uint64(e.ValueSize()) > MaxValueLength:
+		return fmt.Errorf("%w: %d bytes exceeds the maximum of %d bytes", ErrValueTooLarge, e.ValueSize(), uint64(MaxValueLength))
 	}
+
+	return nil
 }
 
 // Encode serializes the entry into bytes (CRC + payload).
 func (e *Entry) Encode() ([]byte, error) {
-	totalSize := headerSize + e.KeySize() + e.ValueSize()
-	buf := make([]byte, totalSize)
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+
+	flags := uint32(flagHasFlags)
+	if e.Tombstone {
+		flags |= flagTombstone
+	}
+	if e.ExpiresAt != 0 {
+		flags |= flagExpires
+	}
+	if e.blob {
+		flags |= flagBlob
+	}
+	flags |= uint32(e.marker)
+
+	buf := make([]byte, e.Size())
 
 	// metadata
 	binary.LittleEndian.PutUint64(buf[timestampOffset:timestampEnd], uint64(e.Timestamp))
-	binary.LittleEndian.PutUint32(buf[keySizeOffset:keySizeEnd], uint32(e.KeySize()))
+	binary.LittleEndian.PutUint32(buf[keySizeOffset:keySizeEnd], flags<<flagsShift|uint32(e.KeySize()))
 	binary.LittleEndian.PutUint32(buf[valueSizeOffset:valueSizeEnd], uint32(e.ValueSize()))
 
 	// key and value
 	copy(buf[keyOffset:], e.Key)
-	copy(buf[e.ValueOffset():], []byte(e.Value))
+	copy(buf[e.ValueOffset():], e.Value)
+	if e.ExpiresAt != 0 {
+		binary.LittleEndian.PutUint64(buf[e.ValueOffset()+int64(e.ValueSize()):], e.ExpiresAt)
+	}
 
 	// calculate CRC over payload
 	crc := crc32.ChecksumIEEE(buf[crcEnd:])
@@ -72,33 +175,67 @@ func (e *Entry) Encode() ([]byte, error) {
 	return buf, nil
 }
 
+// Decode validates a record read as its header and the bytes that follow it:
+// the key, the value and the trailer its flags call for. The CRC is computed
+// over both slices in place, without joining them.
 func Decode(headerBuf, kvBuf []byte, keySize, valueSize uint32) (*DecodedEntry, error) {
 	crc := binary.LittleEndian.Uint32(headerBuf[crcOffset:])
 	key := string(kvBuf[0:keySize])
 
-	payload := make([]byte, metadataSize+len(kvBuf))
-	copy(payload, headerBuf[crcEnd:])
-	copy(payload[metadataSize:], kvBuf)
+	checksum := crc32.ChecksumIEEE(headerBuf[crcEnd:headerSize])
+	checksum = crc32.Update(checksum, crc32.IEEETable, kvBuf)
 
-	if crc32.ChecksumIEEE(payload) != crc {
-		return nil, fmt.Errorf("CRC mismatch for key %s", key)
+	if checksum != crc {
+		return nil, fmt.Errorf("%w for key %q", ErrChecksumMismatch, key)
 	}
 
 	timestamp := binary.LittleEndian.Uint64(headerBuf[timestampOffset:timestampEnd])
+	_, flags := splitKeySizeField(binary.LittleEndian.Uint32(headerBuf[keySizeOffset:]))
 	valueOffset := headerSize + keySize
+	valueEnd := keySize + valueSize
+
+	// Records without flags marked deletions with the tombstone value
+	tombstone := flags&flagTombstone != 0
+	if flags&flagHasFlags == 0 {
+		tombstone = bytes.Equal(kvBuf[keySize:valueEnd], TombstoneValue)
+	}
+
+	var expiresAt uint64
+	if flags&flagExpires != 0 {
+		expiresAt = binary.LittleEndian.Uint64(kvBuf[valueEnd:])
+	}
 
 	decodedEntry := DecodedEntry{
 		key,
 		timestamp,
 		keySize,
 		valueSize,
-		valueOffset + valueSize,
+		valueOffset + valueSize + trailerSize(flags),
 		valueOffset,
+		tombstone,
+		expiresAt,
+		flags & batchMarkers,
+		flags&flagBlob != 0,
 	}
 
 	return &decodedEntry, nil
 }
 
+// splitKeySizeField splits the key size field of a header into the key size
+// and the record flags.
+func splitKeySizeField(field uint32) (uint32, byte) {
+	return field & keySizeMask, byte(field >> flagsShift)
+}
+
+// trailerSize returns how many bytes follow the value of a record with the
+// given flags.
+func trailerSize(flags byte) uint32 {
+	if flags&flagExpires != 0 {
+		return expiresAtSize
+	}
+	return 0
+}
+
 func (e *Entry) KeySize() int {
 	return len(e.Key)
 }
@@ -107,6 +244,15 @@ func (e *Entry) ValueSize() int {
 	return len(e.Value)
 }
 
+// Size returns how many bytes the encoded record takes.
+func (e *Entry) Size() uint64 {
+	size := uint64(headerSize + e.KeySize() + e.ValueSize())
+	if e.ExpiresAt != 0 {
+		size += expiresAtSize
+	}
+	return size
+}
+
 func (e *Entry) ValueOffset() int64 {
 	return headerSize + int64(e.KeySize())
 }
@@ -13,8 +13,8 @@ func TestEntryEncode(t *testing.T) {
 
 	e := &Entry{
 		Timestamp: 1694280000, // fixed timestamp for deterministic test
-		Key:       key,
-		Value:     value,
+		Key:       []byte(key),
+		Value:     []byte(value),
 	}
 
 	data, err := e.Encode()
@@ -46,7 +46,7 @@ func TestEntryEncode(t *testing.T) {
 func TestEntryValueOffset(t *testing.T) {
 	key := "mykey"
 	e := &Entry{
-		Key: key,
+		Key: []byte(key),
 	}
 
 	expected := int64(4 + 8 + 4 + 4 + len(key)) // CRC + Timestamp + KeySize + ValueSize + Key bytes
@@ -56,3 +56,35 @@ func TestEntryValueOffset(t *testing.T) {
 		t.Fatalf("HeaderLength() = %d; want %d", got, expected)
 	}
 }
+
+func TestEntryDecode(t *testing.T) {
+	e := &Entry{
+		Timestamp: 1694280000,
+		Key:       []byte("mykey"),
+		Value:     []byte{0x00, 0xFF, 0x10},
+	}
+
+	data, err := e.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	keySize, valueSize := uint32(e.KeySize()), uint32(e.ValueSize())
+	decoded, err := Decode(data[:headerSize], data[headerSize:], keySize, valueSize)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	if decoded.Key != "mykey" || decoded.Timestamp != e.Timestamp || decoded.ValueSize != valueSize {
+		t.Fatalf("Decode() = %+v; want key %q, timestamp %d, value size %d", decoded, "mykey", e.Timestamp, valueSize)
+	}
+	if decoded.EntrySize != uint32(len(data)) {
+		t.Fatalf("EntrySize = %d; want %d", decoded.EntrySize, len(data))
+	}
+
+	// Any flipped byte in the value must fail the CRC check
+	data[len(data)-1] ^= 0xAA
+	if _, err := Decode(data[:headerSize], data[headerSize:], keySize, valueSize); err == nil {
+		t.Fatal("Decode accepted a corrupted entry")
+	}
+}
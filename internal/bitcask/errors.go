@@ -0,0 +1,74 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned by lookups and GetReader for a key that does
+	// not exist or expired. Get and the other reads that return whether the
+	// key exists report a missing key through that result instead.
+	ErrNotFound = errors.New("key not found")
+
+	// ErrClosed is returned by operations on a database that is not open,
+	// either because Open was never called or because it was closed.
+	ErrClosed = errors.New("database is closed")
+
+	// ErrEmptyKey is returned by writes of an empty key.
+	ErrEmptyKey = errors.New("key is empty")
+
+	// ErrKeyTooLarge is returned by writes of a key larger than the limit set
+	// with SetSizeLimits or than a record can hold, or too large for its
+	// record to fit in a data file even with an empty value.
+	ErrKeyTooLarge = errors.New("key is too large")
+
+	// ErrValueTooLarge is returned by writes of a value larger than the limit
+	// set with SetSizeLimits or than a record can hold, or whose record would
+	// not fit in a data file because of the value.
+	ErrValueTooLarge = errors.New("value is too large")
+
+	// ErrChecksumMismatch is wrapped by errors about records or hints whose
+	// CRC does not match their contents.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrDatabaseLocked is returned by Open when another process holds the
+	// database directory lock.
+	ErrDatabaseLocked = errors.New("database is locked by another process")
+
+	// ErrReadOnly is returned by write operations on a database opened with
+	// OpenReadOnly.
+	ErrReadOnly = errors.New("database is opened read-only")
+
+	// ErrUnsupportedVersion is returned by Open when a data file was written
+	// in a format version this build cannot read.
+	ErrUnsupportedVersion = errors.New("unsupported data file format version")
+
+	// ErrConflict is returned by Update when another writer changed a key the
+	// transaction read before it could commit.
+	ErrConflict = errors.New("transaction conflict")
+
+	// ErrTxnClosed is returned by a transaction used after its function
+	// returned.
+	ErrTxnClosed = errors.New("transaction is closed")
+
+	// ErrSnapshotReleased is returned by a snapshot, or an iterator created
+	// from one, used after the snapshot was released.
+	ErrSnapshotReleased = errors.New("snapshot is released")
+)
+
+// CorruptionError reports a record that failed to decode in the middle of a
+// data file.
+type CorruptionError struct {
+	FileID uint64
+	Offset uint64
+	Err    error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corrupted record in file id %d at offset %d: %v", e.FileID, e.Offset, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,104 @@
+package bitcask
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requireClosed checks that every operation on db fails with ErrClosed.
+func requireClosed(t *testing.T, db *Database) {
+	t.Helper()
+
+	_, _, err := db.Get("key1")
+	require.ErrorIs(t, err, ErrClosed)
+	require.ErrorIs(t, db.Set("key1", "value1"), ErrClosed)
+	require.ErrorIs(t, db.Delete("key1"), ErrClosed)
+	_, _, err = db.TTL("key1")
+	require.ErrorIs(t, err, ErrClosed)
+	_, err = db.SetIfAbsent("key1", "value1")
+	require.ErrorIs(t, err, ErrClosed)
+	_, err = db.History("key1")
+	require.ErrorIs(t, err, ErrClosed)
+	_, err = db.Snapshot()
+	require.ErrorIs(t, err, ErrClosed)
+	require.ErrorIs(t, db.Iterator("", "").Err(), ErrClosed)
+	require.ErrorIs(t, db.View(func(*Txn) error { return nil }), ErrClosed)
+	require.ErrorIs(t, db.Merge(), ErrClosed)
+	require.ErrorIs(t, db.Sync(), ErrClosed)
+
+	var batch Batch
+	batch.Put([]byte("key1"), []byte("value1"))
+	require.ErrorIs(t, db.Write(&batch), ErrClosed)
+}
+
+func TestOperationsOnClosedDatabase(t *testing.T) {
+	db := NewDatabase(t.TempDir(), 0)
+	requireClosed(t, db)
+
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Close())
+	requireClosed(t, db)
+}
+
+func TestWritesRejectInvalidKeys(t *testing.T) {
+	db := NewDatabase(t.TempDir(), 0)
+	db.SetGroupCommit(true)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.ErrorIs(t, db.Set("", "value1"), ErrEmptyKey)
+	require.ErrorIs(t, db.Put(nil, []byte("value1")), ErrEmptyKey)
+	require.ErrorIs(t, db.Delete(""), ErrEmptyKey)
+	require.ErrorIs(t, db.SetWithTTL("", "value1", time.Minute), ErrEmptyKey)
+	_, err := db.CompareAndSwap("", "value1", "value2")
+	require.ErrorIs(t, err, ErrEmptyKey)
+
+	large := strings.Repeat("k", MaxKeyLength+1)
+	require.ErrorIs(t, db.Set(large, "value1"), ErrKeyTooLarge)
+	_, err = db.SetIfAbsent(large, "value1")
+	require.ErrorIs(t, err, ErrKeyTooLarge)
+
+	// A batch with one bad key writes nothing
+	var batch Batch
+	batch.Put([]byte("key1"), []byte("value1"))
+	batch.Put([]byte(""), []byte("value2"))
+	require.ErrorIs(t, db.Write(&batch), ErrEmptyKey)
+
+	err = db.Update(func(tx *Txn) error {
+		require.ErrorIs(t, tx.Set("", "value1"), ErrEmptyKey)
+		require.ErrorIs(t, tx.Delete(nil), ErrEmptyKey)
+		return tx.Set("key2", "value2")
+	})
+	require.NoError(t, err)
+
+	_, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.False(t, ok)
+	val, ok, err := db.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value2", val)
+}
+
+func TestLookupReportsMissingKeys(t *testing.T) {
+	db := NewDatabase(t.TempDir(), 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.SetWithTTL("key2", "value2", time.Minute))
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	_, err := db.lookup("key1", time.Now())
+	require.NoError(t, err)
+	_, err = db.lookup("missing", time.Now())
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = db.lookup("key2", time.Now().Add(time.Hour))
+	require.ErrorIs(t, err, ErrNotFound)
+}
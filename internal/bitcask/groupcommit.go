@@ -0,0 +1,69 @@
+package bitcask
+
+// commitRequest is a write waiting in the group commit queue.
+type commitRequest struct {
+	entries []*Entry
+	err     error
+	done    bool
+	ready   chan struct{} // closed once the write is done or it has to lead
+}
+
+// SetGroupCommit turns group commit on or off. With group commit, concurrent
+// writes are queued and appended together with a single write, and a single
+// fsync covers the whole group whatever the sync policy. It must be called
+// before Open.
+func (db *Database) SetGroupCommit(enabled bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.groupCommit = enabled
+}
+
+// commitGrouped queues the entries and waits until they are written. The first
+// queued writer leads: it appends everything queued so far, wakes the writers
+// it covered once they are synced and hands the lead to the next writer still
+// in the queue.
+func (db *Database) commitGrouped(entries []*Entry) error {
+	req := &commitRequest{entries: entries, ready: make(chan struct{})}
+
+	db.commitMu.Lock()
+	db.commitQueue = append(db.commitQueue, req)
+	leader := len(db.commitQueue) == 1
+	db.commitMu.Unlock()
+
+	if !leader {
+		<-req.ready
+		if req.done {
+			return req.err
+		}
+	}
+
+	// Writers that queue while the leader waits for mu join its group
+	db.mu.Lock()
+	db.commitMu.Lock()
+	batch := db.commitQueue
+	db.commitMu.Unlock()
+
+	groups := make([][]*Entry, len(batch))
+	for i, r := range batch {
+		groups[i] = r.entries
+	}
+
+	err := db.appendEntries(groups)
+	db.mu.Unlock()
+
+	db.commitMu.Lock()
+	db.commitQueue = db.commitQueue[len(batch):]
+	if len(db.commitQueue) > 0 {
+		close(db.commitQueue[0].ready)
+	}
+	db.commitMu.Unlock()
+
+	for _, r := range batch[1:] {
+		r.err = err
+		r.done = true
+		close(r.ready)
+	}
+
+	return err
+}
@@ -0,0 +1,197 @@
+package bitcask
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupCommitConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 512) // small files so groups span rotations
+	db.SetGroupCommit(true)
+	db.SetSyncPolicy(SyncAlways, 0)
+	require.NoError(t, db.Open())
+
+	const workers = 16
+	const iterations = 50
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				key := fmt.Sprintf("w%d-key%d", w, i%10)
+				if err := db.Set(key, fmt.Sprintf("value%d", i)); err != nil {
+					errs <- err
+					return
+				}
+				if i%9 == 0 {
+					if err := db.Delete(key); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.Empty(t, db.commitQueue)
+	require.NoError(t, db.Close())
+
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	for w := 0; w < workers; w++ {
+		for k := 0; k < 10; k++ {
+			key := fmt.Sprintf("w%d-key%d", w, k)
+			last := iterations - 10 + k
+			val, ok, err := db.Get(key)
+			require.NoError(t, err)
+			if last%9 == 0 {
+				require.False(t, ok)
+				continue
+			}
+			require.True(t, ok)
+			require.Equal(t, fmt.Sprintf("value%d", last), val)
+		}
+	}
+}
+
+func TestGroupCommitWritesQueuedRequestsTogether(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	db.SetGroupCommit(true)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	// Hold the write lock so every writer ends up waiting in the queue
+	db.mu.Lock()
+
+	const writers = 8
+	var finished atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, db.Set(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+			finished.Add(1)
+		}(i)
+	}
+
+	require.Eventually(t, func() bool {
+		db.commitMu.Lock()
+		defer db.commitMu.Unlock()
+		return len(db.commitQueue) == writers
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int32(0), finished.Load())
+
+	db.mu.Unlock()
+	wg.Wait()
+
+	for i := 0; i < writers; i++ {
+		val, ok, err := db.Get(fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, fmt.Sprintf("value%d", i), val)
+	}
+}
+
+func TestGroupCommitSyncsEveryGroup(t *testing.T) {
+	dir := t.TempDir()
+	metrics := newRecordingMetrics()
+	db := NewDatabase(dir, 0)
+	db.SetGroupCommit(true)
+	db.SetMetrics(metrics)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	// Queue every writer behind the write lock so they form a single group
+	db.mu.Lock()
+
+	const writers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, db.Set(fmt.Sprintf("key%d", i), "value"))
+		}(i)
+	}
+
+	require.Eventually(t, func() bool {
+		db.commitMu.Lock()
+		defer db.commitMu.Unlock()
+		return len(db.commitQueue) == writers
+	}, time.Second, time.Millisecond)
+
+	db.mu.Unlock()
+	wg.Wait()
+
+	require.Equal(t, int64(writers), metrics.count(MetricWrites))
+	require.Equal(t, int64(1), metrics.count(MetricSyncs))
+
+	// A lone writer is a group of its own and is synced as well
+	require.NoError(t, db.Set("foo", "bar"))
+
+	require.Equal(t, int64(2), metrics.count(MetricSyncs))
+}
+
+func TestGroupCommitReportsErrorsToEveryWriter(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Set("foo", "bar"))
+	require.NoError(t, db.Close())
+
+	db.SetGroupCommit(true)
+	require.NoError(t, db.OpenReadOnly())
+	defer func() { _ = db.Close() }()
+
+	require.ErrorIs(t, db.Set("foo", "baz"), ErrReadOnly)
+	require.ErrorIs(t, db.Delete("foo"), ErrReadOnly)
+}
+
+func benchmarkParallelSet(b *testing.B, groupCommit bool) {
+	db := NewDatabase(b.TempDir(), 0)
+	db.SetGroupCommit(groupCommit)
+	db.SetSyncPolicy(SyncAlways, 0)
+	if err := db.Open(); err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+
+	var counter atomic.Int64
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := counter.Add(1)
+			if err := db.Set(fmt.Sprintf("key%d", i%1000), "value"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkSetSyncAlways(b *testing.B) {
+	benchmarkParallelSet(b, false)
+}
+
+func BenchmarkSetSyncAlwaysGroupCommit(b *testing.B) {
+	benchmarkParallelSet(b, true)
+}
@@ -0,0 +1,253 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Hint records mirror the data records without the value:
+// CRC | Timestamp | Flags + KeySize | ValueSize | ValuePos | Key | [ExpiresAt]
+const (
+	valuePosSize = 8 // 64 bits for value position
+)
+
+const hintHeaderSize = crcSize + timestampSize + keySizeSize + valueSizeSize + valuePosSize
+
+const hintTimestampOffset = crcSize
+const hintKeySizeOffset = hintTimestampOffset + timestampSize
+const hintValueSizeOffset = hintKeySizeOffset + keySizeSize
+const hintValuePosOffset = hintValueSizeOffset + valueSizeSize
+const hintKeyOffset = hintValuePosOffset + valuePosSize
+
+const tmpFileSuffix = ".tmp"
+
+type hintEntry struct {
+	key       string
+	meta      KeydirEntry
+	tombstone bool
+}
+
+func encodeHint(h hintEntry) []byte {
+	flags := uint32(flagHasFlags)
+	if h.tombstone {
+		flags |= flagTombstone
+	}
+	if h.meta.ExpiresAt != 0 {
+		flags |= flagExpires
+	}
+	if h.meta.Blob {
+		flags |= flagBlob
+	}
+
+	buf := make([]byte, hintHeaderSize+uint32(len(h.key))+trailerSize(byte(flags)))
+
+	binary.LittleEndian.PutUint64(buf[hintTimestampOffset:], h.meta.Timestamp)
+	binary.LittleEndian.PutUint32(buf[hintKeySizeOffset:], flags<<flagsShift|uint32(len(h.key)))
+	binary.LittleEndian.PutUint32(buf[hintValueSizeOffset:], h.meta.ValueSize)
+	binary.LittleEndian.PutUint64(buf[hintValuePosOffset:], h.meta.ValuePos)
+	copy(buf[hintKeyOffset:], h.key)
+	if h.meta.ExpiresAt != 0 {
+		binary.LittleEndian.PutUint64(buf[hintKeyOffset+len(h.key):], h.meta.ExpiresAt)
+	}
+
+	crc := crc32.ChecksumIEEE(buf[crcEnd:])
+	binary.LittleEndian.PutUint32(buf[crcOffset:crcEnd], crc)
+
+	return buf
+}
+
+// decodeHints parses a whole hint file. Any malformed record or value pointing
+// past the end of its data file makes the hint invalid, and so do records
+// without flags: those hints predate tombstone flags and may point at values
+// that are really deletions.
+func decodeHints(data []byte, fileID uint64, dataFileSize uint64) ([]hintEntry, error) {
+	var hints []hintEntry
+
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < hintHeaderSize {
+			return nil, fmt.Errorf("truncated hint record at offset %d", offset)
+		}
+
+		record := data[offset:]
+		field, flags := splitKeySizeField(binary.LittleEndian.Uint32(record[hintKeySizeOffset:]))
+		keySize := int(field)
+		if len(record)-hintHeaderSize < keySize+int(trailerSize(flags)) {
+			return nil, fmt.Errorf("truncated hint record at offset %d", offset)
+		}
+		record = record[:hintHeaderSize+keySize+int(trailerSize(flags))]
+
+		crc := binary.LittleEndian.Uint32(record[crcOffset:])
+		if crc32.ChecksumIEEE(record[crcEnd:]) != crc {
+			return nil, fmt.Errorf("%w for hint record at offset %d", ErrChecksumMismatch, offset)
+		}
+
+		if flags&flagHasFlags == 0 {
+			return nil, fmt.Errorf("hint record at offset %d has no flags", offset)
+		}
+
+		meta := KeydirEntry{
+			FileID:    fileID,
+			ValuePos:  binary.LittleEndian.Uint64(record[hintValuePosOffset:]),
+			ValueSize: binary.LittleEndian.Uint32(record[hintValueSizeOffset:]),
+			Timestamp: binary.LittleEndian.Uint64(record[hintTimestampOffset:]),
+			Blob:      flags&flagBlob != 0,
+		}
+		if flags&flagExpires != 0 {
+			meta.ExpiresAt = binary.LittleEndian.Uint64(record[hintKeyOffset+keySize:])
+		}
+		if meta.ValuePos+uint64(meta.ValueSize) > dataFileSize {
+			return nil, fmt.Errorf("hint record at offset %d points past the end of the data file", offset)
+		}
+
+		hints = append(hints, hintEntry{
+			key:       string(record[hintKeyOffset : hintKeyOffset+keySize]),
+			meta:      meta,
+			tombstone: flags&flagTombstone != 0,
+		})
+		offset += len(record)
+	}
+
+	return hints, nil
+}
+
+// writeHintFile writes the hints to path and syncs it to disk.
+func writeHintFile(path string, hints []hintEntry, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create hint file %s: %w", path, err)
+	}
+
+	var buf []byte
+	for _, h := range hints {
+		buf = append(buf, encodeHint(h)...)
+	}
+
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write hint file %s: %w", path, err)
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to sync hint file %s: %w", path, err)
+	}
+
+	return f.Close()
+}
+
+// writeHintForFileID writes the hint file of the file that was just rotated
+// out from the keydir entries that point into it and its tombstones.
+func (db *Database) writeHintForFileID(fileID uint64) error {
+	var hints []hintEntry
+	for key, meta := range db.keydir {
+		if meta.FileID == fileID {
+			hints = append(hints, hintEntry{key: key, meta: meta})
+		}
+	}
+	for key, meta := range db.tombstones {
+		hints = append(hints, hintEntry{key: key, meta: meta, tombstone: true})
+	}
+
+	sort.Slice(hints, func(i, j int) bool {
+		return hints[i].meta.ValuePos < hints[j].meta.ValuePos
+	})
+
+	path := db.getHintFilePathByID(fileID)
+	if err := writeHintFile(path+tmpFileSuffix, hints, db.fileMode); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path+tmpFileSuffix, path); err != nil {
+		return fmt.Errorf("failed to rename hint file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadKeydirFromHintFile loads the keydir entries of a segment from its hint
+// file and returns the size of the data file. The keydir is left untouched if
+// the hint is missing or invalid.
+func (db *Database) loadKeydirFromHintFile(fileID uint64) (uint64, error) {
+	data, err := os.ReadFile(db.getHintFilePathByID(fileID))
+	if err != nil {
+		return 0, err
+	}
+
+	filePath := db.getDBFilePathByID(fileID)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file %s: %w", filePath, err)
+	}
+
+	hints, err := decodeHints(data, fileID, uint64(info.Size()))
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+
+	db.files[fileID] = f
+
+	for _, h := range hints {
+		db.applyHint(h)
+	}
+
+	return uint64(info.Size()), nil
+}
+
+// loadKeydir rebuilds the keydir entries of a segment, preferring its hint
+// file and scanning the data file when there is no usable hint. The newest
+// segment is still being appended to, so it is always scanned. It returns the
+// size of the valid prefix of the data file.
+func (db *Database) loadKeydir(fileID uint64, newest bool) (uint64, error) {
+	header, err := readSegmentHeaderByPath(db.getDBFilePathByID(fileID))
+	if err != nil {
+		return 0, err
+	}
+
+	// Files older than the newest merged one were merged away, so the merge
+	// trigger only counts the files after it
+	if header.Merged() {
+		db.lastMergedID = max(db.lastMergedID, fileID)
+	}
+
+	// Hints of files in an older format hold timestamps in seconds
+	if !newest && header.Version >= nanoTimestampVersion {
+		validSize, err := db.loadKeydirFromHintFile(fileID)
+		if err == nil {
+			return validSize, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			db.logger.Warn("ignoring hint file", "file_id", fileID, "error", err)
+		}
+	}
+
+	return db.loadKeydirFromFileID(fileID, header, newest)
+}
+
+func (db *Database) removeStaleTmpFiles() error {
+	files, err := filepath.Glob(filepath.Join(db.dbPath, "data.*"+tmpFileSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to list temporary files: %w", err)
+	}
+
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return fmt.Errorf("failed to remove stale temporary file %s: %w", f, err)
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) getHintFilePathByID(id uint64) string {
+	return filepath.Join(db.dbPath, fmt.Sprintf("data.%d.hint", id))
+}
@@ -0,0 +1,167 @@
+package bitcask
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotationWritesHintFile(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70) // 70 bytes
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_ = db.Set("key1", "value1") // 30 bytes
+	_ = db.Set("key2", "value2") // 30 bytes
+	_ = db.Set("key3", "value3") // 30 bytes, rotates to data.2
+
+	data, err := os.ReadFile(filepath.Join(dir, "data.1.hint"))
+	require.NoError(t, err)
+
+	hints, err := decodeHints(data, 1, segmentHeaderSize+60)
+	require.NoError(t, err)
+	require.Len(t, hints, 2)
+	require.Equal(t, "key1", hints[0].key)
+	require.Equal(t, db.keydir["key1"], hints[0].meta)
+	require.Equal(t, "key2", hints[1].key)
+	require.Equal(t, db.keydir["key2"], hints[1].meta)
+
+	// The active file has no hint yet
+	_, err = os.Stat(filepath.Join(dir, "data.2.hint"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestOpenLoadsKeydirFromHintFile(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+
+	_ = db.Set("key1", "value1")
+	_ = db.Set("key2", "value2")
+	_ = db.Set("key3", "value3")
+	require.NoError(t, db.Close())
+
+	// Rewrite the hint so key2 points at key1's value. Open can only see
+	// this if it trusts the hint instead of scanning data.1.cask.
+	db = NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	hints := []hintEntry{
+		{key: "key1", meta: db.keydir["key1"]},
+		{key: "key2", meta: db.keydir["key1"]},
+	}
+	require.NoError(t, db.Close())
+	require.NoError(t, writeHintFile(filepath.Join(dir, "data.1.hint"), hints, defaultFileMode))
+
+	db = NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	val, ok, err := db.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value1", val)
+}
+
+func TestOpenFallsBackWhenHintIsCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+
+	_ = db.Set("key1", "value1")
+	_ = db.Set("key2", "value2")
+	_ = db.Set("key3", "value3")
+	require.NoError(t, db.Close())
+
+	hintPath := filepath.Join(dir, "data.1.hint")
+	data, err := os.ReadFile(hintPath)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xAA
+	require.NoError(t, os.WriteFile(hintPath, data, 0644))
+
+	db = NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	for key, want := range map[string]string{"key1": "value1", "key2": "value2", "key3": "value3"} {
+		val, ok, err := db.Get(key)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, want, val)
+	}
+}
+
+func TestOpenFallsBackWhenHintIsMissing(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+
+	_ = db.Set("key1", "value1")
+	_ = db.Set("key2", "value2")
+	_ = db.Set("key3", "value3")
+	require.NoError(t, db.Close())
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "data.1.hint")))
+
+	db = NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	val, ok, err := db.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value2", val)
+}
+
+func TestDecodeHintsRejectsOutOfBoundsValue(t *testing.T) {
+	meta := KeydirEntry{FileID: 1, ValuePos: 50, ValueSize: 20, Timestamp: 1}
+	data := encodeHint(hintEntry{key: "key", meta: meta})
+
+	_, err := decodeHints(data, 1, 60)
+	require.Error(t, err)
+
+	hints, err := decodeHints(data, 1, 70)
+	require.NoError(t, err)
+	require.Equal(t, []hintEntry{{key: "key", meta: meta}}, hints)
+}
+
+func TestMergeWritesHintFiles(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+
+	_ = db.Set("key1", "value1")
+	_ = db.Set("key2", "value2")
+	_ = db.Set("key1", "value3")
+	_ = db.Delete("key2")
+	_ = db.Set("key3", "value4")
+
+	require.NoError(t, db.Merge())
+
+	data, err := os.ReadFile(filepath.Join(dir, "data.1.hint"))
+	require.NoError(t, err)
+	hints, err := decodeHints(data, 1, 1024)
+	require.NoError(t, err)
+	require.Len(t, hints, 1)
+	require.Equal(t, "key1", hints[0].key)
+	require.Equal(t, db.keydir["key1"], hints[0].meta)
+
+	_, err = os.Stat(filepath.Join(dir, "data.2.hint"))
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, db.Close())
+
+	db = NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value3", val)
+
+	_, ok, err = db.Get("key2")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
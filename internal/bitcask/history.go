@@ -0,0 +1,345 @@
+package bitcask
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Version is one value a key held. Older versions stay in the log until a
+// merge drops them, see SetHistoryRetention.
+type Version struct {
+	Value     []byte
+	Timestamp time.Time
+	ExpiresAt time.Time // zero if the value never expires
+	Deleted   bool
+}
+
+// SetHistoryRetention chooses how many older versions of each key merge keeps.
+// A version that was overwritten or deleted is kept if it is one of the last
+// versions replaced, or if it was replaced less than age ago. With both at
+// zero, the default, merge only keeps the current value of each key. It must
+// be called before Open.
+func (db *Database) SetHistoryRetention(versions int, age time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.retainVersions = max(versions, 0)
+	db.retainAge = max(age, 0)
+}
+
+// History returns every version of key still in the log, oldest first,
+// including deletions and the current value. It scans the whole log, so its
+// cost grows with the size of the log rather than with the number of
+// versions: records of other keys are skipped by their header and key, but
+// every one of them is still read.
+func (db *Database) History(key string) ([]Version, error) {
+	state, sizes, err := db.pinLog()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		db.mu.Lock()
+		db.unpin(state)
+		db.mu.Unlock()
+	}()
+
+	var records []hintEntry
+	match := func(k []byte) bool {
+		return string(k) == key
+	}
+	err = db.scanLog(state, sizes, match, func(h hintEntry) {
+		records = append(records, h)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]Version, len(records))
+	for i, r := range records {
+		versions[i] = Version{
+			Timestamp: time.Unix(0, int64(r.meta.Timestamp)),
+			Deleted:   r.tombstone,
+		}
+		if r.meta.ExpiresAt != 0 {
+			versions[i].ExpiresAt = time.Unix(0, int64(r.meta.ExpiresAt))
+		}
+		if r.tombstone {
+			continue
+		}
+
+		value, err := state.readValue(r.meta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read version of key %q: %w", key, err)
+		}
+		versions[i].Value = value
+	}
+
+	return versions, nil
+}
+
+// GetAt returns the value key held at the given time, see GetBytesAt.
+func (db *Database) GetAt(key string, at time.Time) (string, bool, error) {
+	value, exists, err := db.GetBytesAt([]byte(key), at)
+	if err != nil || !exists {
+		return "", false, err
+	}
+
+	return string(value), true, nil
+}
+
+// GetBytesAt returns the value key held at the given time. It only finds
+// versions that were not dropped by a merge yet. Like History, it scans the
+// whole log.
+func (db *Database) GetBytesAt(key []byte, at time.Time) ([]byte, bool, error) {
+	versions, err := db.History(string(key))
+	if err != nil {
+		return nil, false, err
+	}
+
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i].Timestamp.After(at)
+	})
+	if i == 0 {
+		return nil, false, nil
+	}
+
+	v := versions[i-1]
+	if v.Deleted || (!v.ExpiresAt.IsZero() && !at.Before(v.ExpiresAt)) {
+		return nil, false, nil
+	}
+
+	return v.Value, true, nil
+}
+
+// pinLog pins the current state along with the size of each of its files, so
+// the log can be scanned as it was without holding mu.
+func (db *Database) pinLog() (*pinnedState, map[uint64]uint64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(db.files) == 0 {
+		return nil, nil, ErrClosed
+	}
+
+	sizes, err := db.fileSizes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db.pin(), sizes, nil
+}
+
+// fileSizes returns the size of every open data file. The caller must hold
+// mu.
+func (db *Database) fileSizes() (map[uint64]uint64, error) {
+	sizes := make(map[uint64]uint64, len(db.files))
+	for id, f := range db.files {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat file with ID %d: %w", id, err)
+		}
+		sizes[id] = uint64(info.Size())
+	}
+
+	return sizes, nil
+}
+
+// scanLog calls fn with every committed record of the pinned files listed in
+// sizes, in the order they were appended. When match is not nil, only records
+// whose key it accepts are decoded and passed to fn.
+func (db *Database) scanLog(state *pinnedState, sizes map[uint64]uint64, match func([]byte) bool, fn func(hintEntry)) error {
+	ids := make([]uint64, 0, len(sizes))
+	for id := range sizes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	for _, id := range ids {
+		if err := db.scanRecords(state.files[id], id, sizes[id], match, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanRecords calls fn with every committed record in the first size bytes
+// of a data file whose key match accepts, or with all of them when match is
+// nil. Corrupted records are skipped the way CorruptionSkip skips them on
+// load, since loading the file already reported them. Records match rejects
+// are skipped by their header without checking their CRC.
+func (db *Database) scanRecords(f *os.File, fileID uint64, size uint64, match func([]byte) bool, fn func(hintEntry)) error {
+	header, err := readSegmentHeader(f)
+	if err != nil {
+		return err
+	}
+
+	offset := header.DataStart()
+	newReader := func() *bufio.Reader {
+		return bufio.NewReader(io.NewSectionReader(f, int64(offset), int64(size-offset)))
+	}
+	reader := newReader()
+
+	var batch *pendingBatch
+	for offset < size {
+		if match != nil {
+			skipped, err := skipUnmatchedRecord(reader, size-offset, match)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", f.Name(), err)
+			}
+			if skipped > 0 {
+				if batch != nil {
+					batch.skipped++
+				}
+				offset += skipped
+				continue
+			}
+		}
+
+		decodedEntry, payload, err := decodeNextRecord(reader, size-offset)
+		if err != nil {
+			next, found, syncErr := findNextValidEntry(f, offset+1, size)
+			if syncErr != nil {
+				return syncErr
+			}
+			if !found {
+				return nil
+			}
+
+			if batch != nil {
+				batch.damaged = true
+			}
+			offset = next
+			reader = newReader()
+			continue
+		}
+
+		entry := hintEntry{
+			key:       decodedEntry.Key,
+			tombstone: decodedEntry.Tombstone,
+			meta:      db.buildKeydirEntry(header, offset, decodedEntry, fileID),
+		}
+
+		switch {
+		case decodedEntry.Marker == flagBatchBegin:
+			batch = &pendingBatch{offset: offset}
+		case decodedEntry.Marker == flagBatchCommit:
+			count := binary.LittleEndian.Uint32(payload[decodedEntry.KeySize:])
+			if batch != nil && batch.complete(count) {
+				for _, e := range batch.entries {
+					fn(e)
+				}
+			}
+			batch = nil
+		case batch != nil:
+			batch.entries = append(batch.entries, entry)
+		default:
+			fn(entry)
+		}
+		offset += uint64(decodedEntry.EntrySize)
+	}
+
+	return nil
+}
+
+// skipUnmatchedRecord skips the next record in r if match rejects its key,
+// and returns how many bytes it skipped. The header and key are peeked at in
+// the buffer, so nothing is allocated for them. Batch markers, records that
+// do not fit in the remaining bytes and keys larger than the buffer are left
+// for decodeNextRecord, and so is everything when the header cannot be read.
+func skipUnmatchedRecord(r *bufio.Reader, remaining uint64, match func([]byte) bool) (uint64, error) {
+	header, err := r.Peek(headerSize)
+	if err != nil {
+		return 0, nil
+	}
+
+	keySize, flags := splitKeySizeField(binary.LittleEndian.Uint32(header[keySizeOffset:]))
+	valueSize := binary.LittleEndian.Uint32(header[valueSizeOffset:])
+	size := uint64(headerSize) + uint64(keySize) + uint64(valueSize) + uint64(trailerSize(flags))
+	if keySize == 0 || size > remaining || headerSize+int(keySize) > r.Size() {
+		return 0, nil
+	}
+
+	buf, err := r.Peek(headerSize + int(keySize))
+	if err != nil || match(buf[headerSize:]) {
+		return 0, nil
+	}
+
+	if _, err := r.Discard(int(size)); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// historyRecords returns the older versions in the given segments that the
+// retention covers. Once a version is kept, every later version of its key in
+// the segments is kept too, so replaying the merged log still ends on the
+// current one. Current values are left to liveRecords.
+func (db *Database) historyRecords(fileIDs []uint64) ([]mergeRecord, error) {
+	state, sizes, err := db.pinLog()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		db.mu.Lock()
+		db.unpin(state)
+		db.mu.Unlock()
+	}()
+
+	merging := make(map[uint64]bool, len(fileIDs))
+	for _, id := range fileIDs {
+		merging[id] = true
+	}
+
+	// Later versions in the active file count as replacing the merged ones.
+	// A version the retention does not cover now never will, since later
+	// versions only push it further back, so it is dropped as soon as that
+	// is known. Each key is left with the versions the retention covers,
+	// followed by the ones that replaced them.
+	now := time.Now()
+	versions := make(map[string][]hintEntry)
+	err = db.scanLog(state, sizes, nil, func(h hintEntry) {
+		if !merging[h.meta.FileID] && len(versions[h.key]) == 0 {
+			return
+		}
+
+		vs := append(versions[h.key], h)
+		for len(vs) > 1 && !db.retains(vs, 0, now) {
+			vs = vs[1:]
+		}
+		versions[h.key] = vs
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []mergeRecord
+	for key, vs := range versions {
+		if len(vs) < 2 {
+			continue
+		}
+
+		for _, v := range vs {
+			if !merging[v.meta.FileID] {
+				break
+			}
+			records = append(records, mergeRecord{key: key, meta: v.meta, tombstone: v.tombstone, history: true})
+		}
+	}
+
+	return records, nil
+}
+
+// retains reports whether the retention covers version i of vs, the versions
+// of a key oldest first, as of now.
+func (db *Database) retains(vs []hintEntry, i int, now time.Time) bool {
+	replaced := time.Unix(0, int64(vs[i+1].meta.Timestamp))
+	return len(vs)-1-i <= db.retainVersions || now.Sub(replaced) < db.retainAge
+}
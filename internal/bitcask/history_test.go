@@ -0,0 +1,235 @@
+package bitcask
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setClock makes the database stamp its next writes with at.
+func setClock(db *Database, at time.Time) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.clock.now = func() time.Time { return at }
+}
+
+func setAt(t *testing.T, db *Database, key, value string, at time.Time) {
+	setClock(db, at)
+	require.NoError(t, db.Set(key, value))
+}
+
+func deleteAt(t *testing.T, db *Database, key string, at time.Time) {
+	setClock(db, at)
+	require.NoError(t, db.Delete(key))
+}
+
+func historyValues(t *testing.T, db *Database, key string) []string {
+	versions, err := db.History(key)
+	require.NoError(t, err)
+
+	var values []string
+	for _, v := range versions {
+		if v.Deleted {
+			values = append(values, "<deleted>")
+		} else {
+			values = append(values, string(v.Value))
+		}
+	}
+	return values
+}
+
+func requireValueAt(t *testing.T, db *Database, key string, at time.Time, expected string) {
+	val, ok, err := db.GetAt(key, at)
+	require.NoError(t, err)
+	if expected == "" {
+		require.False(t, ok, "expected no value at %v, got %q", at, val)
+		return
+	}
+	require.True(t, ok, "expected %q at %v", expected, at)
+	require.Equal(t, expected, val)
+}
+
+func TestHistoryAndGetAt(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+
+	setAt(t, db, "key1", "value1", time.Unix(1000, 0))
+	setAt(t, db, "key2", "other1", time.Unix(1000, 0))
+	setAt(t, db, "key1", "value2", time.Unix(2000, 0))
+	deleteAt(t, db, "key1", time.Unix(3000, 0))
+	setAt(t, db, "key1", "value3", time.Unix(4000, 0))
+
+	for _, reopen := range []bool{false, true} {
+		if reopen {
+			require.NoError(t, db.Close())
+			require.NoError(t, db.Open())
+		}
+
+		versions, err := db.History("key1")
+		require.NoError(t, err)
+		require.Len(t, versions, 4)
+		require.Equal(t, time.Unix(1000, 0), versions[0].Timestamp)
+		require.Equal(t, time.Unix(3000, 0), versions[2].Timestamp)
+		require.True(t, versions[2].Deleted)
+		require.Equal(t, []string{"value1", "value2", "<deleted>", "value3"}, historyValues(t, db, "key1"))
+
+		requireValueAt(t, db, "key1", time.Unix(500, 0), "")
+		requireValueAt(t, db, "key1", time.Unix(1500, 0), "value1")
+		requireValueAt(t, db, "key1", time.Unix(2000, 0), "value2")
+		requireValueAt(t, db, "key1", time.Unix(3500, 0), "")
+		requireValueAt(t, db, "key1", time.Now(), "value3")
+		requireValueAt(t, db, "missing", time.Now(), "")
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestGetAtHonorsExpiry(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.SetWithTTL("key1", "value1", time.Hour))
+
+	versions, err := db.History("key1")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.False(t, versions[0].ExpiresAt.IsZero())
+
+	requireValueAt(t, db, "key1", time.Now(), "value1")
+	requireValueAt(t, db, "key1", versions[0].ExpiresAt, "")
+}
+
+func TestHistoryLeavesOutUncommittedBatches(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1"))
+
+	// A begin marker and a record without their commit marker
+	db.mu.Lock()
+	data, _, err := encodeGroup([]*Entry{NewEntry("key1", "value2"), NewEntry("key2", "value3")})
+	require.NoError(t, err)
+	_, err = db.activeFile.Write(data[:len(data)-batchMarkerSize])
+	db.mu.Unlock()
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"value1"}, historyValues(t, db, "key1"))
+}
+
+func TestHistoryOfKeyInBatchWithOtherKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	// The records of key2 are skipped, but still count toward the batch
+	var b Batch
+	b.Put([]byte("key2"), []byte("other1"))
+	b.Put([]byte("key1"), []byte("value1"))
+	b.Put([]byte("key2"), []byte("other2"))
+	require.NoError(t, db.Write(&b))
+	require.NoError(t, db.Set("key1", "value2"))
+
+	require.Equal(t, []string{"value1", "value2"}, historyValues(t, db, "key1"))
+	require.Equal(t, []string{"other1", "other2"}, historyValues(t, db, "key2"))
+}
+
+func TestMergeDropsHistoryByDefault(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Set("key1", "value2"))
+	require.NoError(t, db.Set("key1", "value3"))
+	require.NoError(t, db.Set("key2", "value4"))
+	require.NoError(t, db.Set("key3", "value5"))
+
+	require.Equal(t, []string{"value1", "value2", "value3"}, historyValues(t, db, "key1"))
+	require.NoError(t, db.Merge())
+	require.Equal(t, []string{"value3"}, historyValues(t, db, "key1"))
+}
+
+func TestMergeKeepsRetainedVersions(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	db.SetHistoryRetention(1, 0)
+	require.NoError(t, db.Open())
+
+	setAt(t, db, "key1", "value1", time.Unix(1000, 0)) // data.1
+	setAt(t, db, "key1", "value2", time.Unix(2000, 0)) // data.1
+	setAt(t, db, "key1", "value3", time.Unix(3000, 0)) // data.2
+	setAt(t, db, "key2", "value1", time.Unix(4000, 0)) // data.2
+	deleteAt(t, db, "key2", time.Unix(5000, 0))        // data.3
+	setAt(t, db, "key3", "value1", time.Unix(6000, 0)) // data.3
+	setAt(t, db, "key3", "value2", time.Unix(7000, 0)) // data.4 (active)
+	require.Equal(t, uint64(4), db.activeFileID)
+
+	require.NoError(t, db.Merge())
+
+	check := func() {
+		require.Equal(t, []string{"value2", "value3"}, historyValues(t, db, "key1"))
+		require.Equal(t, []string{"value1", "<deleted>"}, historyValues(t, db, "key2"))
+		require.Equal(t, []string{"value1", "value2"}, historyValues(t, db, "key3"))
+
+		requireValueAt(t, db, "key1", time.Unix(1500, 0), "")
+		requireValueAt(t, db, "key1", time.Unix(2500, 0), "value2")
+		requireValueAt(t, db, "key2", time.Unix(4500, 0), "value1")
+
+		val, ok, err := db.Get("key1")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "value3", val)
+
+		// The kept value of key2 must not come back on reload
+		_, ok, err = db.Get("key2")
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+	check()
+
+	// Without hints the merged files are replayed record by record
+	require.NoError(t, db.Close())
+	hints, err := filepath.Glob(filepath.Join(dir, "data.*.hint"))
+	require.NoError(t, err)
+	require.NotEmpty(t, hints)
+	for _, hint := range hints {
+		require.NoError(t, os.Remove(hint))
+	}
+	require.NoError(t, db.Open())
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestMergeKeepsVersionsReplacedWithinRetentionAge(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	db.SetHistoryRetention(0, time.Hour)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	now := time.Now()
+	setAt(t, db, "key1", "value1", now.Add(-3*time.Hour))
+	setAt(t, db, "key1", "value2", now.Add(-2*time.Hour))
+	setAt(t, db, "key1", "value3", now.Add(-30*time.Minute))
+	setAt(t, db, "key1", "value4", now)
+	setAt(t, db, "key2", "value5", now)
+
+	require.NoError(t, db.Merge())
+
+	// value1 was replaced two hours ago, value2 only half an hour ago
+	require.Equal(t, []string{"value2", "value3", "value4"}, historyValues(t, db, "key1"))
+}
@@ -0,0 +1,229 @@
+package bitcask
+
+import (
+	"math/rand/v2"
+	"slices"
+)
+
+// indexNode is a node of the ordered index, a persistent treap that keeps
+// the keydir sorted by key. Nodes are never modified once they are reachable
+// from a root: every change copies the nodes on its path and returns a new
+// root, so holding on to a root pins the index as it was at that moment.
+type indexNode struct {
+	key      string
+	meta     KeydirEntry
+	priority uint32
+	left     *indexNode
+	right    *indexNode
+}
+
+func (n *indexNode) clone() *indexNode {
+	c := *n
+	return &c
+}
+
+// indexPut returns a root where key maps to meta.
+func indexPut(root *indexNode, key string, meta KeydirEntry) *indexNode {
+	if indexFind(root, key) != nil {
+		return indexReplace(root, key, meta)
+	}
+
+	return indexInsert(root, &indexNode{key: key, meta: meta, priority: rand.Uint32()})
+}
+
+// indexReplace changes the meta of a key that is in the tree.
+func indexReplace(root *indexNode, key string, meta KeydirEntry) *indexNode {
+	c := root.clone()
+	switch {
+	case key == root.key:
+		c.meta = meta
+	case key < root.key:
+		c.left = indexReplace(root.left, key, meta)
+	default:
+		c.right = indexReplace(root.right, key, meta)
+	}
+	return c
+}
+
+// indexInsert inserts a node whose key is not in the tree yet.
+func indexInsert(root *indexNode, n *indexNode) *indexNode {
+	if root == nil {
+		return n
+	}
+
+	if n.priority > root.priority {
+		n.left, n.right = indexSplit(root, n.key)
+		return n
+	}
+
+	c := root.clone()
+	if n.key < root.key {
+		c.left = indexInsert(root.left, n)
+	} else {
+		c.right = indexInsert(root.right, n)
+	}
+	return c
+}
+
+// indexSplit splits a tree that does not hold key into the keys below and
+// above it.
+func indexSplit(root *indexNode, key string) (*indexNode, *indexNode) {
+	if root == nil {
+		return nil, nil
+	}
+
+	c := root.clone()
+	if root.key < key {
+		c.right, root = indexSplit(root.right, key)
+		return c, root
+	}
+
+	root, c.left = indexSplit(root.left, key)
+	return root, c
+}
+
+// indexDelete returns a root without key.
+func indexDelete(root *indexNode, key string) *indexNode {
+	if indexFind(root, key) == nil {
+		return root
+	}
+
+	return indexRemove(root, key)
+}
+
+// indexRemove removes a key that is in the tree.
+func indexRemove(root *indexNode, key string) *indexNode {
+	if key == root.key {
+		return indexJoin(root.left, root.right)
+	}
+
+	c := root.clone()
+	if key < root.key {
+		c.left = indexRemove(root.left, key)
+	} else {
+		c.right = indexRemove(root.right, key)
+	}
+	return c
+}
+
+// indexJoin joins two trees where every key of a is below every key of b.
+func indexJoin(a, b *indexNode) *indexNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	if a.priority > b.priority {
+		c := a.clone()
+		c.right = indexJoin(a.right, b)
+		return c
+	}
+
+	c := b.clone()
+	c.left = indexJoin(a, b.left)
+	return c
+}
+
+func indexFind(root *indexNode, key string) *indexNode {
+	for root != nil {
+		switch {
+		case key == root.key:
+			return root
+		case key < root.key:
+			root = root.left
+		default:
+			root = root.right
+		}
+	}
+	return nil
+}
+
+// indexCursor walks a pinned index in key order.
+type indexCursor struct {
+	stack []*indexNode
+}
+
+// seek positions the cursor before the first key at or above start.
+func (c *indexCursor) seek(root *indexNode, start string) {
+	c.stack = c.stack[:0]
+	for root != nil {
+		if root.key >= start {
+			c.stack = append(c.stack, root)
+			root = root.left
+		} else {
+			root = root.right
+		}
+	}
+}
+
+// next returns the next node in key order, or nil at the end.
+func (c *indexCursor) next() *indexNode {
+	if len(c.stack) == 0 {
+		return nil
+	}
+
+	n := c.stack[len(c.stack)-1]
+	c.stack = c.stack[:len(c.stack)-1]
+	for r := n.right; r != nil; r = r.left {
+		c.stack = append(c.stack, r)
+	}
+	return n
+}
+
+// indexBuild builds the index of a whole keydir in one pass: the keys are
+// sorted and each node is hung off the rightmost path of the tree built so
+// far, below the last node with a higher priority. This takes a fraction of
+// the time and allocations of inserting every key on its own.
+func indexBuild(keydir map[string]KeydirEntry) *indexNode {
+	keys := make([]string, 0, len(keydir))
+	for key := range keydir {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	nodes := make([]indexNode, len(keys))
+	var path []*indexNode // the rightmost path, root first
+	for i, key := range keys {
+		n := &nodes[i]
+		*n = indexNode{key: key, meta: keydir[key], priority: rand.Uint32()}
+
+		for len(path) > 0 && path[len(path)-1].priority < n.priority {
+			n.left = path[len(path)-1]
+			path = path[:len(path)-1]
+		}
+		if len(path) > 0 {
+			path[len(path)-1].right = n
+		}
+		path = append(path, n)
+	}
+
+	if len(path) == 0 {
+		return nil
+	}
+	return path[0]
+}
+
+// setKeydir points key at meta in both the keydir and the ordered index.
+// While the database loads, only the keydir is updated and the index is built
+// from it once loading is done. The caller must hold mu exclusively.
+func (db *Database) setKeydir(key string, meta KeydirEntry) {
+	db.keydir[key] = meta
+	if !db.loading {
+		db.index = indexPut(db.index, key, meta)
+	}
+}
+
+// deleteKeydir removes key from both the keydir and the ordered index. The
+// caller must hold mu exclusively.
+func (db *Database) deleteKeydir(key string) {
+	if _, ok := db.keydir[key]; !ok {
+		return
+	}
+
+	delete(db.keydir, key)
+	if !db.loading {
+		db.index = indexDelete(db.index, key)
+	}
+}
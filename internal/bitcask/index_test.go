@@ -0,0 +1,113 @@
+package bitcask
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func indexKeys(root *indexNode) []string {
+	var c indexCursor
+	c.seek(root, "")
+
+	var keys []string
+	for n := c.next(); n != nil; n = c.next() {
+		keys = append(keys, n.key)
+	}
+	return keys
+}
+
+func TestIndexMatchesMap(t *testing.T) {
+	var root *indexNode
+	want := make(map[string]KeydirEntry)
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("key%03d", rng.IntN(300))
+		if rng.IntN(3) == 0 {
+			root = indexDelete(root, key)
+			delete(want, key)
+			continue
+		}
+		meta := KeydirEntry{ValuePos: uint64(i)}
+		root = indexPut(root, key, meta)
+		want[key] = meta
+	}
+
+	var keys []string
+	for key, meta := range want {
+		keys = append(keys, key)
+		require.Equal(t, meta, indexFind(root, key).meta)
+	}
+	sort.Strings(keys)
+	require.Equal(t, keys, indexKeys(root))
+}
+
+// requireHeap checks that no node has a higher priority than its parent.
+func requireHeap(t *testing.T, n *indexNode) {
+	for _, child := range []*indexNode{n.left, n.right} {
+		if child != nil {
+			require.LessOrEqual(t, child.priority, n.priority)
+			requireHeap(t, child)
+		}
+	}
+}
+
+func TestIndexBuild(t *testing.T) {
+	require.Nil(t, indexBuild(nil))
+
+	keydir := make(map[string]KeydirEntry)
+	for i := 0; i < 1000; i++ {
+		keydir[fmt.Sprintf("key%04d", i)] = KeydirEntry{ValuePos: uint64(i)}
+	}
+
+	root := indexBuild(keydir)
+	requireHeap(t, root)
+	require.Len(t, indexKeys(root), len(keydir))
+	require.True(t, sort.StringsAreSorted(indexKeys(root)))
+	for key, meta := range keydir {
+		require.Equal(t, meta, indexFind(root, key).meta)
+	}
+
+	// Live writes keep working on the built tree
+	root = indexDelete(root, "key0500")
+	root = indexPut(root, "key0500a", KeydirEntry{})
+	requireHeap(t, root)
+	require.Nil(t, indexFind(root, "key0500"))
+	require.NotNil(t, indexFind(root, "key0500a"))
+}
+
+func TestIndexRootsArePersistent(t *testing.T) {
+	var root *indexNode
+	for _, key := range []string{"b", "d", "a", "c"} {
+		root = indexPut(root, key, KeydirEntry{})
+	}
+	pinned := root
+
+	root = indexDelete(root, "b")
+	root = indexPut(root, "e", KeydirEntry{})
+	root = indexPut(root, "a", KeydirEntry{ValuePos: 1})
+
+	require.Equal(t, []string{"a", "b", "c", "d"}, indexKeys(pinned))
+	require.Equal(t, uint64(0), indexFind(pinned, "a").meta.ValuePos)
+	require.Equal(t, []string{"a", "c", "d", "e"}, indexKeys(root))
+}
+
+func TestIndexCursorSeek(t *testing.T) {
+	var root *indexNode
+	for _, key := range []string{"apple", "banana", "cherry"} {
+		root = indexPut(root, key, KeydirEntry{})
+	}
+
+	var c indexCursor
+	c.seek(root, "b")
+	require.Equal(t, "banana", c.next().key)
+	require.Equal(t, "cherry", c.next().key)
+	require.Nil(t, c.next())
+
+	c.seek(root, "d")
+	require.Nil(t, c.next())
+}
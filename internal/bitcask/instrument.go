@@ -0,0 +1,56 @@
+package bitcask
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Names of the metrics the database reports to its Metrics sink.
+const (
+	MetricWrites        = "gocask.writes"         // records appended
+	MetricBytesWritten  = "gocask.bytes_written"  // bytes appended to data and blob files
+	MetricReads         = "gocask.reads"          // values read by gets and iterators
+	MetricSyncs         = "gocask.syncs"          // syncs of the active file
+	MetricMerges        = "gocask.merges"         // completed merges
+	MetricMergeDuration = "gocask.merge_duration" // time a merge took
+	MetricExpired       = "gocask.expired"        // expired keys evicted
+)
+
+// Metrics receives counters and timings from the database. It is called on
+// hot paths, so implementations must be cheap and safe for concurrent use.
+type Metrics interface {
+	Count(name string, delta int64)
+	Observe(name string, d time.Duration)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Count(string, int64)           {}
+func (noopMetrics) Observe(string, time.Duration) {}
+
+// SetLogger chooses where the database logs the problems it works around,
+// like skipped corrupted records or failed background syncs. A nil logger
+// means slog.Default(). It must be called before Open.
+func (db *Database) SetLogger(logger *slog.Logger) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	db.logger = logger
+}
+
+// SetMetrics chooses the sink metrics are reported to. A nil sink drops them.
+// It must be called before Open.
+func (db *Database) SetMetrics(metrics Metrics) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+
+	db.metrics = metrics
+}
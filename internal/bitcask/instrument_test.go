@@ -0,0 +1,105 @@
+package bitcask
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingMetrics struct {
+	mu       sync.Mutex
+	counts   map[string]int64
+	observed map[string]int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{counts: make(map[string]int64), observed: make(map[string]int)}
+}
+
+func (m *recordingMetrics) Count(name string, delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[name] += delta
+}
+
+func (m *recordingMetrics) count(name string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[name]
+}
+
+func (m *recordingMetrics) Observe(name string, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observed[name]++
+}
+
+func TestLoggerReceivesWarnings(t *testing.T) {
+	dir := writeCorruptedDatabase(t, 25)
+
+	var logs bytes.Buffer
+	db := NewDatabase(dir, 0)
+	db.SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.Contains(t, logs.String(), "level=WARN")
+	require.Contains(t, logs.String(), `msg="skipping corrupted entry" file_id=1`)
+}
+
+func TestMetricsAreReported(t *testing.T) {
+	dir := t.TempDir()
+	metrics := newRecordingMetrics()
+	db := NewDatabase(dir, 70)
+	db.SetMetrics(metrics)
+	db.SetSyncPolicy(SyncAlways, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	var batch Batch
+	batch.Put([]byte("key1"), []byte("value1"))
+	batch.Put([]byte("key2"), []byte("value2"))
+	require.NoError(t, db.Write(&batch))         // data.1
+	require.NoError(t, db.Set("key1", "value3")) // data.2
+	require.NoError(t, db.Set("key3", "value4")) // data.3
+
+	_, _, err := db.Get("key1")
+	require.NoError(t, err)
+	require.NoError(t, db.Merge())
+
+	require.Equal(t, int64(4), metrics.counts[MetricWrites])
+	require.Greater(t, metrics.counts[MetricBytesWritten], int64(4*30))
+	require.Equal(t, int64(1), metrics.counts[MetricReads])
+	require.GreaterOrEqual(t, metrics.counts[MetricSyncs], int64(3))
+	require.Equal(t, int64(1), metrics.counts[MetricMerges])
+	require.Equal(t, 1, metrics.observed[MetricMergeDuration])
+}
+
+func TestSetFileMode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	db := NewDatabase(dir, 70)
+	db.SetFileMode(0600)
+
+	// Open creates the missing directory
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Set("key2", "value2"))
+	require.NoError(t, db.Set("key3", "value3")) // rotates and writes data.1.hint
+	require.NoError(t, db.Close())
+
+	for _, name := range []string{"data.1.cask", "data.1.hint", "data.2.cask", lockFileName} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm(), name)
+	}
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), info.Mode().Perm())
+}
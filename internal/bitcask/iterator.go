@@ -0,0 +1,225 @@
+package bitcask
+
+import (
+	"fmt"
+	"iter"
+	"strings"
+)
+
+// Iterator walks keys in lexicographic order. It sees the keys as they were
+// when it was created, whatever is written meanwhile, and reads values only
+// when asked for them. It can be driven with Next or ranged over, and unlike
+// the sequences of Database.All, Scan and Prefix it reports why it stopped:
+//
+//	it := db.PrefixIterator("user:")
+//	for key, value := range it.All() {
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// An open iterator keeps the files it reads from open after a merge replaces
+// them, so it must be closed once done with. Next closes it when it runs out
+// of keys, and so does leaving a range loop over All or Keys, even early.
+type Iterator struct {
+	db     *Database
+	state  *pinnedState
+	snap   *Snapshot // the snapshot the state is borrowed from, if any
+	cursor indexCursor
+	end    string // exclusive upper bound, empty when unbounded
+	prefix string
+	node   *indexNode
+	err    error
+	closed bool
+}
+
+// All returns a sequence of every key and its value, in lexicographic order.
+// Each range loop sees the keys as they were when it started, reads values
+// lazily and releases what it pinned when it ends, even early. A value that
+// cannot be read ends the loop; use Iterator to find out why.
+//
+//	for key, value := range db.All() {
+//		...
+//	}
+func (db *Database) All() iter.Seq2[[]byte, []byte] {
+	return pairSeq(func() *Iterator { return db.Iterator("", "") })
+}
+
+// Keys returns a sequence of every key, in lexicographic order, without
+// reading any value.
+func (db *Database) Keys() iter.Seq[[]byte] {
+	return keySeq(func() *Iterator { return db.Iterator("", "") })
+}
+
+// Scan returns a sequence of the keys from start up to, but not including,
+// end, and their values. An empty end scans to the last key. See All.
+func (db *Database) Scan(start string, end string) iter.Seq2[[]byte, []byte] {
+	return pairSeq(func() *Iterator { return db.Iterator(start, end) })
+}
+
+// Prefix returns a sequence of the keys that start with prefix and their
+// values. See All.
+func (db *Database) Prefix(prefix string) iter.Seq2[[]byte, []byte] {
+	return pairSeq(func() *Iterator { return db.PrefixIterator(prefix) })
+}
+
+// Iterator returns an iterator over the keys from start up to, but not
+// including, end. An empty end iterates to the last key.
+func (db *Database) Iterator(start string, end string) *Iterator {
+	return db.newIterator(start, end, "")
+}
+
+// PrefixIterator returns an iterator over the keys that start with prefix.
+func (db *Database) PrefixIterator(prefix string) *Iterator {
+	return db.newIterator(prefix, "", prefix)
+}
+
+// pairSeq returns a sequence over the keys and values of an iterator that
+// each range loop creates with newIterator.
+func pairSeq(newIterator func() *Iterator) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for key, value := range newIterator().All() {
+			if !yield([]byte(key), value) {
+				return
+			}
+		}
+	}
+}
+
+// keySeq returns a sequence over the keys of an iterator that each range loop
+// creates with newIterator.
+func keySeq(newIterator func() *Iterator) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		for key := range newIterator().Keys() {
+			if !yield([]byte(key)) {
+				return
+			}
+		}
+	}
+}
+
+func (db *Database) newIterator(start, end, prefix string) *Iterator {
+	it := &Iterator{db: db, end: end, prefix: prefix}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(db.files) == 0 {
+		it.err = ErrClosed
+		it.closed = true
+		return it
+	}
+
+	it.state = db.pin()
+	it.cursor.seek(it.state.root, start)
+
+	return it
+}
+
+// Next moves to the next key and reports whether there is one.
+func (it *Iterator) Next() bool {
+	if it.closed {
+		return false
+	}
+
+	for {
+		n := it.cursor.next()
+		if n == nil || (it.end != "" && n.key >= it.end) || !strings.HasPrefix(n.key, it.prefix) {
+			it.node = nil
+			it.Close()
+			return false
+		}
+
+		// Keys that expire while the iterator is open are still returned
+		if n.meta.expired(it.state.now) {
+			continue
+		}
+
+		it.node = n
+		return true
+	}
+}
+
+// Key returns the current key.
+func (it *Iterator) Key() string {
+	if it.node == nil {
+		return ""
+	}
+	return it.node.key
+}
+
+// Value reads the value of the current key from its data file.
+func (it *Iterator) Value() ([]byte, error) {
+	if it.node == nil {
+		return nil, fmt.Errorf("iterator is not positioned on a key")
+	}
+
+	it.db.mu.RLock()
+	defer it.db.mu.RUnlock()
+
+	if it.snap != nil && it.snap.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	it.db.metrics.Count(MetricReads, 1)
+	return it.state.readValue(it.node.meta)
+}
+
+// All returns a sequence of the remaining keys and their values. Values are
+// read from the data files one at a time as the loop asks for them. A failed
+// read ends the sequence and is reported by Err.
+func (it *Iterator) All() iter.Seq2[string, []byte] {
+	return func(yield func(string, []byte) bool) {
+		defer it.Close()
+
+		for it.Next() {
+			value, err := it.Value()
+			if err != nil {
+				it.err = fmt.Errorf("failed to read key %q: %w", it.Key(), err)
+				return
+			}
+			if !yield(it.Key(), value) {
+				return
+			}
+		}
+	}
+}
+
+// Keys returns a sequence of the remaining keys without reading any value.
+func (it *Iterator) Keys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		defer it.Close()
+
+		for it.Next() {
+			if !yield(it.Key()) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error that kept the iterator from starting or that ended
+// a range loop over All, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the iterator. It is safe to call more than once.
+func (it *Iterator) Close() {
+	if it.closed {
+		return
+	}
+	it.closed = true
+	it.node = nil
+
+	// A snapshot iterator leaves the state to the snapshot
+	if it.snap != nil {
+		return
+	}
+
+	it.db.mu.Lock()
+	defer it.db.mu.Unlock()
+
+	it.db.unpin(it.state)
+}
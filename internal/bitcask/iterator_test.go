@@ -0,0 +1,266 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func collectKeys(t *testing.T, it *Iterator) []string {
+	defer it.Close()
+	require.NoError(t, it.Err())
+
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys
+}
+
+func TestIteratorsReturnKeysInOrder(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+
+	for _, key := range []string{"user:2", "order:1", "user:1", "user:10", "zeta", "gone"} {
+		require.NoError(t, db.Set(key, "v-"+key))
+	}
+	require.NoError(t, db.Delete("gone"))
+
+	for _, reopen := range []bool{false, true} {
+		if reopen {
+			require.NoError(t, db.Close())
+			require.NoError(t, db.Open())
+		}
+
+		require.Equal(t, []string{"order:1", "user:1", "user:10", "user:2", "zeta"}, collectKeys(t, db.Iterator("", "")))
+		require.Equal(t, []string{"user:1", "user:10", "user:2"}, collectKeys(t, db.PrefixIterator("user:")))
+		require.Equal(t, []string{"user:10", "user:2"}, collectKeys(t, db.Iterator("user:10", "user:3")))
+		require.Equal(t, []string{"user:2", "zeta"}, collectKeys(t, db.Iterator("user:2", "")))
+		require.Empty(t, collectKeys(t, db.PrefixIterator("missing")))
+	}
+	require.NoError(t, db.Close())
+}
+
+func TestIteratorValues(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Set("key2", "value2"))
+
+	it := db.Iterator("", "")
+	defer it.Close()
+
+	var values []string
+	for it.Next() {
+		value, err := it.Value()
+		require.NoError(t, err)
+		values = append(values, string(value))
+	}
+	require.Equal(t, []string{"value1", "value2"}, values)
+
+	_, err := it.Value()
+	require.Error(t, err)
+}
+
+func TestIteratorIsStableDuringWrites(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+
+	it := db.Iterator("", "")
+	defer it.Close()
+
+	require.True(t, it.Next())
+	require.Equal(t, "key0", it.Key())
+
+	require.NoError(t, db.Delete("key2"))
+	require.NoError(t, db.Set("key3", "changed"))
+	require.NoError(t, db.Set("key25", "added"))
+
+	var keys []string
+	var values []string
+	for it.Next() {
+		value, err := it.Value()
+		require.NoError(t, err)
+		keys = append(keys, it.Key())
+		values = append(values, string(value))
+	}
+	require.Equal(t, []string{"key1", "key2", "key3", "key4"}, keys)
+	require.Equal(t, []string{"value1", "value2", "value3", "value4"}, values)
+}
+
+func TestIteratorSkipsExpiredKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.SetWithTTL("key2", "value2", 50*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+
+	require.Equal(t, []string{"key1"}, collectKeys(t, db.Iterator("", "")))
+}
+
+func TestMergeKeepsFilesOfOpenIterators(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1")) // data.1
+	require.NoError(t, db.Set("key2", "value2")) // data.1
+
+	it := db.Iterator("", "")
+	require.NoError(t, db.Set("key1", "value3")) // data.2
+	require.NoError(t, db.Set("key3", "value4")) // data.2
+	require.NoError(t, db.Set("key4", "value5")) // data.3 (active)
+
+	// The merge drops the value of key1 the iterator still sees
+	require.NoError(t, db.Merge())
+
+	require.True(t, it.Next())
+	value, err := it.Value()
+	require.NoError(t, err)
+	require.Equal(t, "value1", string(value))
+
+	require.NotEmpty(t, db.retired)
+	it.Close()
+	require.Empty(t, db.retired)
+
+	// The merge repointed the index along with the keydir
+	it = db.Iterator("", "")
+	defer it.Close()
+	var values []string
+	for it.Next() {
+		value, err := it.Value()
+		require.NoError(t, err)
+		values = append(values, string(value))
+	}
+	require.Equal(t, []string{"value3", "value2", "value4", "value5"}, values)
+}
+
+func TestRangeOverIterator(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	for _, key := range []string{"user:2", "order:1", "user:1"} {
+		require.NoError(t, db.Set(key, "v-"+key))
+	}
+
+	got := make(map[string]string)
+	var keys []string
+	it := db.PrefixIterator("user:")
+	for key, value := range it.All() {
+		keys = append(keys, key)
+		got[key] = string(value)
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, []string{"user:1", "user:2"}, keys)
+	require.Equal(t, map[string]string{"user:1": "v-user:1", "user:2": "v-user:2"}, got)
+
+	require.Empty(t, db.fileRefs)
+}
+
+func TestRangeOverDatabase(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	for _, key := range []string{"user:2", "order:1", "user:1"} {
+		require.NoError(t, db.Set(key, "v-"+key))
+	}
+
+	var pairs []string
+	for key, value := range db.All() {
+		pairs = append(pairs, string(key)+"="+string(value))
+	}
+	require.Equal(t, []string{"order:1=v-order:1", "user:1=v-user:1", "user:2=v-user:2"}, pairs)
+
+	pairs = nil
+	for key, value := range db.Prefix("user:") {
+		pairs = append(pairs, string(key)+"="+string(value))
+	}
+	require.Equal(t, []string{"user:1=v-user:1", "user:2=v-user:2"}, pairs)
+
+	var keys []string
+	for key := range db.Keys() {
+		keys = append(keys, string(key))
+	}
+	require.Equal(t, []string{"order:1", "user:1", "user:2"}, keys)
+
+	// Every loop starts over from the keys as they are then
+	seq := db.Scan("order:1", "user:2")
+	require.NoError(t, db.Set("order:2", "v-order:2"))
+	keys = nil
+	for key := range seq {
+		keys = append(keys, string(key))
+		if len(keys) == 2 {
+			break
+		}
+	}
+	require.Equal(t, []string{"order:1", "order:2"}, keys)
+	require.Empty(t, db.fileRefs)
+}
+
+func TestRangeOverIteratorStopsEarly(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("key%d", i), "value"))
+	}
+
+	it := db.Iterator("", "")
+	for key := range it.All() {
+		if key == "key1" {
+			break
+		}
+	}
+	require.NoError(t, it.Err())
+
+	// Leaving the loop closed the iterator and unpinned its files
+	require.Empty(t, db.fileRefs)
+	require.False(t, it.Next())
+	require.NoError(t, db.Merge())
+}
+
+func TestRangeOverIteratorReportsReadErrors(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Set("key2", "value2"))
+
+	it := db.Iterator("", "")
+
+	// Cut the value of key2 out from under the iterator
+	require.NoError(t, os.Truncate(filepath.Join(dir, "data.1.cask"), segmentHeaderSize+40))
+
+	var keys []string
+	for key := range it.All() {
+		keys = append(keys, key)
+	}
+	require.Equal(t, []string{"key1"}, keys)
+	require.ErrorContains(t, it.Err(), "key2")
+}
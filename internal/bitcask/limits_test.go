@@ -0,0 +1,104 @@
+package bitcask
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func activeFileSize(t *testing.T, db *Database) int64 {
+	t.Helper()
+
+	info, err := os.Stat(db.getDBFilePathByID(db.activeFileID))
+	require.NoError(t, err)
+	return info.Size()
+}
+
+func TestKeySizeLimit(t *testing.T) {
+	db := NewDatabase(t.TempDir(), 0)
+	db.SetSizeLimits(8, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set(strings.Repeat("k", 8), "value1"))
+	size := activeFileSize(t, db)
+
+	key := strings.Repeat("k", 9)
+	require.ErrorIs(t, db.Set(key, "value1"), ErrKeyTooLarge)
+	require.ErrorIs(t, db.Delete(key), ErrKeyTooLarge)
+	_, err := db.SetIfAbsent(key, "value1")
+	require.ErrorIs(t, err, ErrKeyTooLarge)
+	err = db.Update(func(tx *Txn) error {
+		return tx.Set(key, "value1")
+	})
+	require.ErrorIs(t, err, ErrKeyTooLarge)
+
+	require.Equal(t, size, activeFileSize(t, db))
+}
+
+func TestValueSizeLimit(t *testing.T) {
+	db := NewDatabase(t.TempDir(), 0)
+	db.SetSizeLimits(0, 16)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", strings.Repeat("v", 16)))
+	size := activeFileSize(t, db)
+
+	value := strings.Repeat("v", 17)
+	require.ErrorIs(t, db.Set("key1", value), ErrValueTooLarge)
+	require.ErrorIs(t, db.SetWithTTL("key1", value, time.Minute), ErrValueTooLarge)
+	_, err := db.CompareAndSwap("key1", strings.Repeat("v", 16), value)
+	require.ErrorIs(t, err, ErrValueTooLarge)
+
+	var batch Batch
+	batch.Put([]byte("key2"), []byte("value2"))
+	batch.Put([]byte("key3"), []byte(value))
+	require.ErrorIs(t, db.Write(&batch), ErrValueTooLarge)
+
+	require.Equal(t, size, activeFileSize(t, db))
+
+	val, _, err := db.Get("key1")
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("v", 16), val)
+}
+
+func TestRecordLargerThanMaxFileSize(t *testing.T) {
+	db := NewDatabase(t.TempDir(), 64)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	// A 20 byte header and a 25 byte key leave 19 bytes for the value, and
+	// not enough for the 20 byte reference to a blob file
+	key := strings.Repeat("k", 25)
+	require.ErrorIs(t, db.Set(key, strings.Repeat("v", 20)), ErrValueTooLarge)
+	require.Equal(t, int64(segmentHeaderSize), activeFileSize(t, db))
+
+	// A key that leaves no room for any value is the one too large
+	key = strings.Repeat("k", 45)
+	require.ErrorIs(t, db.Set(key, "v"), ErrKeyTooLarge)
+	require.ErrorIs(t, db.Delete(key), ErrKeyTooLarge)
+	require.ErrorIs(t, db.Set(key[:44], "v"), ErrValueTooLarge)
+	require.Equal(t, int64(segmentHeaderSize), activeFileSize(t, db))
+	require.Equal(t, uint64(1), db.activeFileID)
+
+	require.NoError(t, db.Set(key[:25], strings.Repeat("v", 19)))
+	require.NoError(t, db.Set("key2", "value2"))
+	require.Equal(t, uint64(2), db.activeFileID)
+}
+
+func TestSetSizeLimitsStaysWithinTheFormat(t *testing.T) {
+	db := NewDatabase(t.TempDir(), 0)
+	db.SetSizeLimits(MaxKeyLength+1, MaxValueLength+1)
+	require.Equal(t, uint64(MaxKeyLength), db.maxKeySize)
+	// Values that do not fit in a record go to blob files
+	require.Equal(t, uint64(MaxValueLength+1), db.maxValueSize)
+
+	db.SetSizeLimits(0, 0)
+	require.Equal(t, uint64(MaxKeyLength), db.maxKeySize)
+	require.Equal(t, uint64(math.MaxUint64), db.maxValueSize)
+}
@@ -0,0 +1,43 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const lockFileName = "LOCK"
+
+// acquireLock takes an advisory lock on the LOCK file in dbPath. Writers take
+// it exclusively, read-only opens share it.
+func (db *Database) acquireLock(exclusive bool) error {
+	path := filepath.Join(db.dbPath, lockFileName)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, db.fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open lock file %s: %w", path, err)
+	}
+
+	if err := lockFile(f, exclusive); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to lock %s: %w", db.dbPath, err)
+	}
+
+	db.lockFile = f
+	return nil
+}
+
+func (db *Database) releaseLock() error {
+	if db.lockFile == nil {
+		return nil
+	}
+
+	f := db.lockFile
+	db.lockFile = nil
+
+	if err := unlockFile(f); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to unlock %s: %w", db.dbPath, err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package bitcask
+
+import "os"
+
+// Advisory locks are only implemented on unix systems. Elsewhere the LOCK file
+// is still created but does not keep other processes out.
+//
+// Merge also relies on unix semantics: it renames merge files over segments
+// and removes segments while the database, and any pinned snapshot,
+// transaction or iterator, still holds them open. Windows refuses both, so
+// there Merge fails once the manifest is written, and the next Open finishes
+// the swap when nothing holds the old files anymore.
+
+func lockFile(_ *os.File, _ bool) error {
+	return nil
+}
+
+func unlockFile(_ *os.File) error {
+	return nil
+}
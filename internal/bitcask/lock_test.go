@@ -0,0 +1,78 @@
+package bitcask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenFailsWhenDatabaseIsLocked(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+
+	other := NewDatabase(dir, 0)
+	require.ErrorIs(t, other.Open(), ErrDatabaseLocked)
+	require.ErrorIs(t, other.OpenReadOnly(), ErrDatabaseLocked)
+
+	// Closing releases the lock
+	require.NoError(t, db.Close())
+	require.NoError(t, other.Open())
+	require.NoError(t, other.Close())
+}
+
+func TestOpenReadOnlySharesLock(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Set("foo", "bar"))
+	require.NoError(t, db.Close())
+
+	reader1 := NewDatabase(dir, 0)
+	require.NoError(t, reader1.OpenReadOnly())
+	defer func() { _ = reader1.Close() }()
+
+	reader2 := NewDatabase(dir, 0)
+	require.NoError(t, reader2.OpenReadOnly())
+	defer func() { _ = reader2.Close() }()
+
+	for _, r := range []*Database{reader1, reader2} {
+		val, ok, err := r.Get("foo")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "bar", val)
+	}
+
+	// A writer cannot join while readers hold the lock
+	writer := NewDatabase(dir, 0)
+	require.ErrorIs(t, writer.Open(), ErrDatabaseLocked)
+}
+
+func TestReadOnlyDatabaseRejectsWrites(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Set("foo", "bar"))
+	require.NoError(t, db.Close())
+
+	require.NoError(t, db.OpenReadOnly())
+	defer func() { _ = db.Close() }()
+
+	require.ErrorIs(t, db.Set("foo", "baz"), ErrReadOnly)
+	require.ErrorIs(t, db.Delete("foo"), ErrReadOnly)
+	require.ErrorIs(t, db.Merge(), ErrReadOnly)
+
+	val, ok, err := db.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", val)
+}
+
+func TestOpenReadOnlyRequiresExistingData(t *testing.T) {
+	db := NewDatabase(t.TempDir(), 0)
+	require.Error(t, db.OpenReadOnly())
+
+	// The failed open must not keep the lock
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Close())
+}
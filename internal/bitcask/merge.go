@@ -0,0 +1,656 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	mergeFileSuffix      = ".merge"
+	mergeManifestName    = "merge.manifest"
+	defaultMergeMinFiles = 2
+)
+
+// The merge manifest commits a merge once every merge file is on disk. It
+// lists the segments the merge replaces, the first UsedCount of which take
+// over a merge file:
+// CRC | UsedCount | FileID...
+const (
+	manifestCountSize = 4 // 32 bits for the number of used IDs
+	manifestIDSize    = 8 // 64 bits for each segment ID
+)
+
+type mergeRecord struct {
+	key       string
+	meta      KeydirEntry
+	tombstone bool
+	history   bool // an older version kept by the history retention
+}
+
+// mergeMove records where a key lived before a merge and where it lives after.
+type mergeMove struct {
+	from KeydirEntry
+	to   KeydirEntry
+}
+
+// Merge compacts every immutable segment (all but the active one) into fresh
+// segments that only hold the live value of each key. Overwritten values and
+// tombstones are dropped and the old files are removed. Dropping tombstones is
+// safe because every older value of a deleted key lives in a merged segment.
+// With SetHistoryRetention, the older versions it covers are copied along in
+// log order, tombstones included. Blob files no record references anymore
+// are removed once the merge is done.
+//
+// The active file is never touched, so writes keep going to it while the
+// merge runs. Values are copied holding only short read locks and the write
+// lock is only taken to swap the new files in. Merged files reuse the lowest
+// IDs of the segments they replace, which keeps them ordered before the
+// active file when the keydir is rebuilt.
+//
+// Records of one segment can end up in the merge file of the next one, so
+// the old segments are only touched once a manifest commits the merge. A
+// crash after that makes Open finish the swap, and a crash before it leaves
+// the old segments as they were.
+func (db *Database) Merge() error {
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+
+	start := time.Now()
+
+	db.mu.RLock()
+	if db.readOnly {
+		db.mu.RUnlock()
+		return ErrReadOnly
+	}
+
+	if db.activeFile == nil {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+
+	fileIDs := db.immutableFileIDs()
+	records := db.liveRecords(fileIDs)
+	db.mu.RUnlock()
+
+	if len(fileIDs) == 0 {
+		return nil
+	}
+
+	if db.retainVersions > 0 || db.retainAge > 0 {
+		history, err := db.historyRecords(fileIDs)
+		if err != nil {
+			return err
+		}
+		records = mergeHistory(records, history)
+	}
+
+	moved, usedIDs, blobs, err := db.writeMergeFiles(fileIDs, records)
+	if err == nil {
+		err = db.writeMergeHintFiles(usedIDs, moved)
+	}
+	if err == nil {
+		err = db.writeMergeManifest(fileIDs, usedIDs)
+	}
+	if err != nil {
+		db.removeMergeFiles(fileIDs)
+		return err
+	}
+
+	if err := db.swapMergeFiles(fileIDs, usedIDs, moved); err != nil {
+		return err
+	}
+
+	if err := db.collectBlobs(fileIDs[len(fileIDs)-1], blobs); err != nil {
+		return err
+	}
+
+	db.metrics.Count(MetricMerges, 1)
+	db.metrics.Observe(MetricMergeDuration, time.Since(start))
+
+	return nil
+}
+
+// immutableFileIDs returns the IDs of every segment except the active one in
+// ascending order. The caller must hold mu.
+func (db *Database) immutableFileIDs() []uint64 {
+	var ids []uint64
+	for id := range db.files {
+		if id != db.activeFileID {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+// liveRecords returns the unexpired keydir entries stored in the given
+// segments, in the same order they were appended to the log. The caller must
+// hold mu.
+func (db *Database) liveRecords(fileIDs []uint64) []mergeRecord {
+	merging := make(map[uint64]bool, len(fileIDs))
+	for _, id := range fileIDs {
+		merging[id] = true
+	}
+
+	now := time.Now()
+	var records []mergeRecord
+	for key, meta := range db.keydir {
+		if merging[meta.FileID] && !meta.expired(now) {
+			records = append(records, mergeRecord{key: key, meta: meta})
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].meta.FileID != records[j].meta.FileID {
+			return records[i].meta.FileID < records[j].meta.FileID
+		}
+		return records[i].meta.ValuePos < records[j].meta.ValuePos
+	})
+
+	return records
+}
+
+// mergeHistory adds the older versions a merge keeps to the live records,
+// in log order. A version the history scan saw replaced may be the live record
+// liveRecords picked before the write replacing it, and is only copied once.
+func mergeHistory(live, history []mergeRecord) []mergeRecord {
+	type position struct{ fileID, valuePos uint64 }
+	seen := make(map[position]bool, len(live))
+	for _, r := range live {
+		seen[position{r.meta.FileID, r.meta.ValuePos}] = true
+	}
+
+	records := live
+	for _, r := range history {
+		if !seen[position{r.meta.FileID, r.meta.ValuePos}] {
+			records = append(records, r)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].meta.FileID != records[j].meta.FileID {
+			return records[i].meta.FileID < records[j].meta.FileID
+		}
+		return records[i].meta.ValuePos < records[j].meta.ValuePos
+	})
+
+	return records
+}
+
+// writeMergeFiles copies the live records into temporary merge files. It
+// returns where each moved key now lives, the segment IDs the merge files
+// will take over and the blobs the copied records reference.
+func (db *Database) writeMergeFiles(fileIDs []uint64, records []mergeRecord) (map[string]mergeMove, []uint64, map[uint64]struct{}, error) {
+	moved := make(map[string]mergeMove)
+	blobs := make(map[uint64]struct{})
+	var usedIDs []uint64
+
+	var out *os.File
+	var outSize uint64
+	closeOut := func() error {
+		if out == nil {
+			return nil
+		}
+		if err := out.Sync(); err != nil {
+			return fmt.Errorf("failed to sync merge file: %w", err)
+		}
+		err := out.Close()
+		out = nil
+		return err
+	}
+
+	for _, r := range records {
+		// Merged segments are immutable and only removed by a merge, so
+		// each read only needs to keep the files map stable. Blob
+		// references are copied as they are.
+		var value []byte
+		if !r.tombstone {
+			db.mu.RLock()
+			var err error
+			value, err = db.readRecordValue(r.meta)
+			db.mu.RUnlock()
+			if err != nil {
+				_ = closeOut()
+				return nil, nil, nil, fmt.Errorf("failed to read key %q for merge: %w", r.key, err)
+			}
+		}
+		if r.meta.Blob {
+			ref, err := decodeBlobRef(value)
+			if err != nil {
+				_ = closeOut()
+				return nil, nil, nil, fmt.Errorf("failed to read key %q for merge: %w", r.key, err)
+			}
+			blobs[ref.id] = struct{}{}
+		}
+
+		entry := &Entry{Timestamp: r.meta.Timestamp, Key: []byte(r.key), Value: value, Tombstone: r.tombstone, ExpiresAt: r.meta.ExpiresAt, blob: r.meta.Blob}
+		data, err := entry.Encode()
+		if err != nil {
+			_ = closeOut()
+			return nil, nil, nil, fmt.Errorf("failed to encode entry: %w", err)
+		}
+
+		// Start a new merge file when the current one is full. Once every
+		// replaced ID is taken the last file keeps growing, so merged data
+		// never gets an ID at or above the active file.
+		full := out != nil && outSize+uint64(len(data)) > db.maxFileSize
+		if out == nil || (full && len(usedIDs) < len(fileIDs)) {
+			if err := closeOut(); err != nil {
+				return nil, nil, nil, err
+			}
+			id := fileIDs[len(usedIDs)]
+			out, err = os.OpenFile(db.mergeFilePathByID(id), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, db.fileMode)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to create merge file with ID %d: %w", id, err)
+			}
+			header := newSegmentHeader()
+			header.Flags |= segmentFlagMerged
+			if _, err := out.Write(header.Encode()); err != nil {
+				_ = closeOut()
+				return nil, nil, nil, fmt.Errorf("failed to write merge file header: %w", err)
+			}
+			usedIDs = append(usedIDs, id)
+			outSize = 0
+		}
+
+		if _, err := out.Write(data); err != nil {
+			_ = closeOut()
+			return nil, nil, nil, fmt.Errorf("failed to write merge entry: %w", err)
+		}
+
+		if r.history {
+			outSize += uint64(len(data))
+			continue
+		}
+
+		moved[r.key] = mergeMove{
+			from: r.meta,
+			to: KeydirEntry{
+				FileID:    usedIDs[len(usedIDs)-1],
+				ValuePos:  segmentHeaderSize + outSize + uint64(entry.ValueOffset()),
+				ValueSize: uint32(entry.ValueSize()),
+				Timestamp: entry.Timestamp,
+				ExpiresAt: entry.ExpiresAt,
+				Blob:      entry.blob,
+			},
+		}
+		outSize += uint64(len(data))
+	}
+
+	if err := closeOut(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return moved, usedIDs, blobs, nil
+}
+
+// writeMergeHintFiles writes a hint file next to every merge file.
+func (db *Database) writeMergeHintFiles(usedIDs []uint64, moved map[string]mergeMove) error {
+	hints := make(map[uint64][]hintEntry, len(usedIDs))
+	for key, m := range moved {
+		hints[m.to.FileID] = append(hints[m.to.FileID], hintEntry{key: key, meta: m.to})
+	}
+
+	for _, id := range usedIDs {
+		fileHints := hints[id]
+		sort.Slice(fileHints, func(i, j int) bool {
+			return fileHints[i].meta.ValuePos < fileHints[j].meta.ValuePos
+		})
+		if err := writeHintFile(db.mergeHintFilePathByID(id), fileHints, db.fileMode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// swapMergeFiles renames the merge files over the segments they replace,
+// removes the segments that are no longer needed and then the manifest.
+// Handles to the old files stay open while a snapshot, transaction or
+// iterator still reads from them, which only unix systems allow, see
+// lock_other.go.
+func (db *Database) swapMergeFiles(fileIDs, usedIDs []uint64, moved map[string]mergeMove) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, id := range usedIDs {
+		if err := db.swapInMergeFile(id); err != nil {
+			return err
+		}
+
+		path := db.getDBFilePathByID(id)
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open merged file %s: %w", path, err)
+		}
+		if old, ok := db.files[id]; ok {
+			db.retireFile(old)
+		}
+		db.files[id] = f
+	}
+
+	for _, id := range fileIDs[len(usedIDs):] {
+		if old, ok := db.files[id]; ok {
+			db.retireFile(old)
+			delete(db.files, id)
+		}
+		if err := db.removeMergedSegment(id); err != nil {
+			return err
+		}
+	}
+
+	if err := db.removeMergeManifest(); err != nil {
+		return err
+	}
+	db.lastMergedID = fileIDs[len(fileIDs)-1]
+
+	// Only repoint keys that were not rewritten while the merge was running
+	for key, m := range moved {
+		if current, ok := db.keydir[key]; ok && current == m.from {
+			db.setKeydir(key, m.to)
+		}
+	}
+
+	// Keys still pointing into the merged segments expired and were dropped
+	merged := make(map[uint64]bool, len(fileIDs))
+	for _, id := range fileIDs {
+		merged[id] = true
+	}
+	for key, meta := range db.keydir {
+		if _, ok := moved[key]; !ok && merged[meta.FileID] {
+			db.deleteKeydir(key)
+		}
+	}
+
+	return nil
+}
+
+// swapInMergeFile renames a merge file and its hint over the segment it
+// replaces. Either rename may already have happened before a crash, so it
+// can be repeated.
+func (db *Database) swapInMergeFile(id uint64) error {
+	if _, err := os.Stat(db.mergeFilePathByID(id)); err == nil {
+		// Drop the old hint first so it can never describe the new data file
+		if err := removeIfExists(db.getHintFilePathByID(id)); err != nil {
+			return fmt.Errorf("failed to remove hint file with ID %d: %w", id, err)
+		}
+
+		if err := os.Rename(db.mergeFilePathByID(id), db.getDBFilePathByID(id)); err != nil {
+			return fmt.Errorf("failed to swap in merge file with ID %d: %w", id, err)
+		}
+	}
+
+	if _, err := os.Stat(db.mergeHintFilePathByID(id)); err == nil {
+		if err := os.Rename(db.mergeHintFilePathByID(id), db.getHintFilePathByID(id)); err != nil {
+			return fmt.Errorf("failed to swap in merge hint file with ID %d: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// removeMergedSegment removes a segment whose records a merge moved into the
+// merge files of other segments.
+func (db *Database) removeMergedSegment(id uint64) error {
+	if err := removeIfExists(db.getHintFilePathByID(id)); err != nil {
+		return fmt.Errorf("failed to remove hint file with ID %d: %w", id, err)
+	}
+	if err := removeIfExists(db.getDBFilePathByID(id)); err != nil {
+		return fmt.Errorf("failed to remove merged file with ID %d: %w", id, err)
+	}
+	return nil
+}
+
+func (db *Database) removeMergeFiles(fileIDs []uint64) {
+	for _, id := range fileIDs {
+		_ = os.Remove(db.mergeFilePathByID(id))
+		_ = os.Remove(db.mergeHintFilePathByID(id))
+	}
+	_ = os.Remove(db.mergeManifestPath())
+	_ = os.Remove(db.mergeManifestPath() + tmpFileSuffix)
+}
+
+func (db *Database) mergeManifestPath() string {
+	return filepath.Join(db.dbPath, mergeManifestName)
+}
+
+func encodeMergeManifest(fileIDs, usedIDs []uint64) []byte {
+	buf := make([]byte, crcSize+manifestCountSize+len(fileIDs)*manifestIDSize)
+	binary.LittleEndian.PutUint32(buf[crcSize:], uint32(len(usedIDs)))
+	for i, id := range fileIDs {
+		binary.LittleEndian.PutUint64(buf[crcSize+manifestCountSize+i*manifestIDSize:], id)
+	}
+
+	crc := crc32.ChecksumIEEE(buf[crcEnd:])
+	binary.LittleEndian.PutUint32(buf[crcOffset:crcEnd], crc)
+
+	return buf
+}
+
+// decodeMergeManifest returns the segments a merge replaces and how many of
+// them take over a merge file.
+func decodeMergeManifest(data []byte) ([]uint64, int, error) {
+	if len(data) < crcSize+manifestCountSize || (len(data)-crcSize-manifestCountSize)%manifestIDSize != 0 {
+		return nil, 0, fmt.Errorf("invalid merge manifest of %d bytes", len(data))
+	}
+	if crc32.ChecksumIEEE(data[crcEnd:]) != binary.LittleEndian.Uint32(data[crcOffset:]) {
+		return nil, 0, fmt.Errorf("%w for merge manifest", ErrChecksumMismatch)
+	}
+
+	usedCount := int(binary.LittleEndian.Uint32(data[crcSize:]))
+	fileIDs := make([]uint64, 0, (len(data)-crcSize-manifestCountSize)/manifestIDSize)
+	for offset := crcSize + manifestCountSize; offset < len(data); offset += manifestIDSize {
+		fileIDs = append(fileIDs, binary.LittleEndian.Uint64(data[offset:]))
+	}
+	if usedCount > len(fileIDs) {
+		return nil, 0, fmt.Errorf("invalid merge manifest: %d used IDs out of %d", usedCount, len(fileIDs))
+	}
+
+	return fileIDs, usedCount, nil
+}
+
+// writeMergeManifest commits a merge whose merge files and hints are synced.
+// It is written to a temporary file and renamed, so it is either complete or
+// missing. The directory is synced before the rename, so the entries of the
+// merge files are on disk whenever the manifest is, and again after it.
+func (db *Database) writeMergeManifest(fileIDs, usedIDs []uint64) error {
+	path := db.mergeManifestPath()
+	f, err := os.OpenFile(path+tmpFileSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, db.fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to create merge manifest: %w", err)
+	}
+
+	_, err = f.Write(encodeMergeManifest(fileIDs, usedIDs))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write merge manifest: %w", err)
+	}
+
+	if err := syncDir(db.dbPath); err != nil {
+		return err
+	}
+	if err := os.Rename(path+tmpFileSuffix, path); err != nil {
+		return fmt.Errorf("failed to rename merge manifest: %w", err)
+	}
+
+	return syncDir(db.dbPath)
+}
+
+// removeMergeManifest removes the manifest once the swap it describes is on
+// disk. The removal is synced too, so a later merge that is cut short before
+// its own manifest can never be swapped in by this one.
+func (db *Database) removeMergeManifest() error {
+	if err := syncDir(db.dbPath); err != nil {
+		return err
+	}
+
+	if err := removeIfExists(db.mergeManifestPath()); err != nil {
+		return fmt.Errorf("failed to remove merge manifest: %w", err)
+	}
+
+	return syncDir(db.dbPath)
+}
+
+// finishInterruptedMerge completes the swap of a merge that was committed
+// but cut short by a crash. Without a manifest there is nothing to finish,
+// and any merge files left behind are removed as stale.
+func (db *Database) finishInterruptedMerge() error {
+	data, err := os.ReadFile(db.mergeManifestPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read merge manifest: %w", err)
+	}
+
+	// Finishing the swap writes to the directory
+	if db.readOnly {
+		return fmt.Errorf("an interrupted merge must be finished by opening the database for writing: %w", ErrReadOnly)
+	}
+
+	fileIDs, usedCount, err := decodeMergeManifest(data)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range fileIDs[:usedCount] {
+		if err := db.swapInMergeFile(id); err != nil {
+			return err
+		}
+	}
+	for _, id := range fileIDs[usedCount:] {
+		if err := db.removeMergedSegment(id); err != nil {
+			return err
+		}
+	}
+
+	db.logger.Warn("finished interrupted merge", "files", len(fileIDs))
+
+	return db.removeMergeManifest()
+}
+
+func (db *Database) removeStaleMergeFiles() error {
+	files, err := filepath.Glob(filepath.Join(db.dbPath, "data.*"+mergeFileSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to list merge files: %w", err)
+	}
+
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return fmt.Errorf("failed to remove stale merge file %s: %w", f, err)
+		}
+	}
+
+	return removeIfExists(db.mergeManifestPath() + tmpFileSuffix)
+}
+
+func (db *Database) mergeFilePathByID(id uint64) string {
+	return db.getDBFilePathByID(id) + mergeFileSuffix
+}
+
+func (db *Database) mergeHintFilePathByID(id uint64) string {
+	return db.getHintFilePathByID(id) + mergeFileSuffix
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SetMergeTrigger makes the database merge on its own. Every interval it
+// counts the data files that became immutable since the last merge and merges
+// once there are at least minFiles of them, which defaults to 2 when zero. A
+// zero interval turns automatic merges off, which is the default. It must be
+// called before Open.
+func (db *Database) SetMergeTrigger(interval time.Duration, minFiles int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if minFiles <= 0 {
+		minFiles = defaultMergeMinFiles
+	}
+
+	db.mergeInterval = interval
+	db.mergeMinFiles = minFiles
+}
+
+// mergeDue reports whether enough data files became immutable since the last
+// merge for SetMergeTrigger to start one.
+func (db *Database) mergeDue() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	unmerged := 0
+	for id := range db.files {
+		if id != db.activeFileID && id > db.lastMergedID {
+			unmerged++
+		}
+	}
+
+	return unmerged >= db.mergeMinFiles
+}
+
+// startMerger starts the background loop of SetMergeTrigger. The caller must
+// hold mu.
+func (db *Database) startMerger() {
+	if db.readOnly || db.mergeInterval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	db.stopMerger = stop
+	db.mergerWG.Add(1)
+
+	go func() {
+		defer db.mergerWG.Done()
+
+		ticker := time.NewTicker(db.mergeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if !db.mergeDue() {
+					continue
+				}
+				if err := db.Merge(); err != nil {
+					db.logger.Warn("background merge failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// stopBackgroundMerger stops the loop started by startMerger and waits for it
+// to exit, which includes a merge it is running. It must be called without
+// holding mu.
+func (db *Database) stopBackgroundMerger() {
+	db.mu.Lock()
+	stop := db.stopMerger
+	db.stopMerger = nil
+	db.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		db.mergerWG.Wait()
+	}
+}
@@ -0,0 +1,251 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeDropsOverwrittenAndDeletedKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70) // 70 bytes
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_ = db.Set("key1", "value1") // data.1
+	_ = db.Set("key2", "value2") // data.1
+	_ = db.Set("key1", "value3") // data.2
+	_ = db.Delete("key2")        // data.2
+	_ = db.Set("key3", "value4") // data.3
+	_ = db.Set("key4", "value5") // data.3
+	_ = db.Set("key5", "value6") // data.4 (active)
+
+	require.Equal(t, uint64(4), db.activeFileID)
+
+	require.NoError(t, db.Merge())
+
+	// Only key1 and key3/key4 were live in the immutable segments
+	require.Len(t, db.files, 3)
+	_, err := os.Stat(filepath.Join(dir, "data.3.cask"))
+	require.True(t, os.IsNotExist(err))
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value3", val)
+
+	_, ok, err = db.Get("key2")
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, ok = db.keydir["key2"]
+	require.False(t, ok)
+
+	val, ok, err = db.Get("key4")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value5", val)
+
+	val, ok, err = db.Get("key5")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value6", val)
+}
+
+func TestMergePersistence(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+
+	_ = db.Set("key1", "value1")
+	_ = db.Set("key2", "value2")
+	_ = db.Set("key1", "value3")
+	_ = db.Delete("key2")
+	_ = db.Set("key3", "value4")
+
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+
+	db = NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value3", val)
+
+	_, ok, err = db.Get("key2")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	val, ok, err = db.Get("key3")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value4", val)
+
+	// Writes keep going to the active file after reopening
+	require.NoError(t, db.Set("key4", "value5"))
+	val, ok, err = db.Get("key4")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value5", val)
+}
+
+func TestMergeKeepsKeysRewrittenDuringMerge(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_ = db.Set("key1", "value1")
+	_ = db.Set("key2", "value2")
+	_ = db.Set("key3", "value3")
+
+	// Run the merge in steps and overwrite a key before the swap
+	fileIDs := db.immutableFileIDs()
+	moved, usedIDs, _, err := db.writeMergeFiles(fileIDs, db.liveRecords(fileIDs))
+	require.NoError(t, err)
+	require.NoError(t, db.writeMergeHintFiles(usedIDs, moved))
+
+	require.NoError(t, db.Set("key1", "newer"))
+
+	require.NoError(t, db.swapMergeFiles(fileIDs, usedIDs, moved))
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "newer", val)
+
+	val, ok, err = db.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value2", val)
+}
+
+func TestOpenRemovesStaleMergeFiles(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "data.1.cask"+mergeFileSuffix)
+	require.NoError(t, os.WriteFile(stale, []byte("partial"), 0644))
+
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_, err := os.Stat(stale)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestMergeTrigger(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	db.SetMergeTrigger(10*time.Millisecond, 3)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1")) // data.1
+	require.NoError(t, db.Set("key1", "value2")) // data.1
+	require.NoError(t, db.Set("key1", "value3")) // data.2
+	require.NoError(t, db.Set("key1", "value4")) // data.2
+	require.NoError(t, db.Set("key1", "value5")) // data.3
+
+	// Two immutable files are not enough
+	time.Sleep(50 * time.Millisecond)
+	db.mu.RLock()
+	require.Len(t, db.files, 3)
+	db.mu.RUnlock()
+
+	require.NoError(t, db.Set("key1", "value6")) // data.3
+	require.NoError(t, db.Set("key1", "value7")) // data.4
+
+	// Three are, and every value in them was overwritten
+	require.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return len(db.files) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value7", val)
+}
+
+func TestMergeTriggerSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	db.SetMergeTrigger(time.Hour, 2)
+	require.NoError(t, db.Open())
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("key%02d", i), fmt.Sprintf("value%02d", i)))
+	}
+	require.True(t, db.mergeDue())
+	require.NoError(t, db.Merge())
+	require.False(t, db.mergeDue())
+	lastMergedID := db.lastMergedID
+	require.NoError(t, db.Close())
+
+	// A new process learns what was merged from the merged files themselves
+	db = NewDatabase(dir, 70)
+	db.SetMergeTrigger(time.Hour, 2)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.Equal(t, lastMergedID, db.lastMergedID)
+	require.False(t, db.mergeDue())
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("key%02d", i), "new"))
+	}
+	require.True(t, db.mergeDue())
+}
+
+func TestOpenFinishesMergeInterruptedAfterFirstSwap(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 500)
+	require.NoError(t, db.Open())
+	for i := 0; i < 40; i++ {
+		require.NoError(t, db.Set(fmt.Sprintf("key%03d", i), fmt.Sprintf("value%03d", i)))
+	}
+	require.NoError(t, db.Close())
+
+	// With a smaller limit the records of data.1 spill into the merge file
+	// that replaces data.2
+	db = NewDatabase(dir, 200)
+	require.NoError(t, db.Open())
+
+	fileIDs := db.immutableFileIDs()
+	require.Equal(t, []uint64{1, 2}, fileIDs)
+	moved, usedIDs, _, err := db.writeMergeFiles(fileIDs, db.liveRecords(fileIDs))
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2}, usedIDs)
+	require.NoError(t, db.writeMergeHintFiles(usedIDs, moved))
+	require.NoError(t, db.writeMergeManifest(fileIDs, usedIDs))
+
+	// Crash right after the first file was swapped in
+	require.NoError(t, db.swapInMergeFile(1))
+	require.NoError(t, db.Close())
+
+	reader := NewDatabase(dir, 200)
+	require.ErrorIs(t, reader.OpenReadOnly(), ErrReadOnly)
+
+	db = NewDatabase(dir, 200)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	for i := 0; i < 40; i++ {
+		val, ok, err := db.Get(fmt.Sprintf("key%03d", i))
+		require.NoError(t, err)
+		require.True(t, ok, i)
+		require.Equal(t, fmt.Sprintf("value%03d", i), val)
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, "*"+mergeFileSuffix))
+	require.NoError(t, err)
+	require.Empty(t, leftovers)
+	_, err = os.Stat(filepath.Join(dir, mergeManifestName))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,146 @@
+package bitcask
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Migrate rewrites every data file at dbPath written in an older format,
+// including files that predate headers, into the current format and returns
+// how many files it rewrote. The database must not be open anywhere else.
+//
+// Each file is converted on its own and swapped in with a rename, so an
+// interrupted migration can simply be run again: files that are already in
+// the current format are skipped.
+func Migrate(dbPath string) (int, error) {
+	db := NewDatabase(dbPath, 0)
+	if err := db.acquireLock(true); err != nil {
+		return 0, err
+	}
+	defer func() { _ = db.releaseLock() }()
+
+	// A previous run may have left a half written file behind
+	if err := db.removeStaleTmpFiles(); err != nil {
+		return 0, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dbPath, "data.*.cask"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list segment files: %w", err)
+	}
+
+	migrated := 0
+	for _, id := range parseSegmentFileIDs(files) {
+		header, err := readSegmentHeaderByPath(db.getDBFilePathByID(id))
+		if err != nil {
+			return migrated, err
+		}
+		if header.Version == segmentFormatVersion {
+			continue
+		}
+
+		if err := db.migrateFile(id, header); err != nil {
+			return migrated, fmt.Errorf("failed to migrate file id %d: %w", id, err)
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// migrateFile copies every record of a file in an older format into a new
+// file in the current one and swaps it in. Records are re-encoded, so legacy
+// tombstones get their flag and timestamps move to nanoseconds. A torn record
+// at the end is dropped, any other bad record stops the migration.
+func (db *Database) migrateFile(fileID uint64, header segmentHeader) error {
+	path := db.getDBFilePathByID(fileID)
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	size := uint64(info.Size())
+
+	tmpPath := path + tmpFileSuffix
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = out.Close() }()
+
+	w := bufio.NewWriter(out)
+	migrated := newSegmentHeader()
+	migrated.Flags = header.Flags
+	if _, err := w.Write(migrated.Encode()); err != nil {
+		return err
+	}
+
+	offset := header.DataStart()
+	if _, err := in.Seek(int64(offset), io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(in)
+	for {
+		decoded, kvBuf, err := decodeNextRecord(reader, size-offset)
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			if _, found, syncErr := findNextValidEntry(in, offset+1, size); syncErr != nil || found {
+				return &CorruptionError{FileID: fileID, Offset: offset, Err: err}
+			}
+			break
+		} else if err != nil {
+			return &CorruptionError{FileID: fileID, Offset: offset, Err: err}
+		}
+
+		entry := &Entry{
+			Timestamp: header.Timestamp(decoded.Timestamp),
+			Key:       kvBuf[:decoded.KeySize],
+			Tombstone: decoded.Tombstone,
+			ExpiresAt: decoded.ExpiresAt,
+			marker:    decoded.Marker,
+		}
+		if !decoded.Tombstone {
+			entry.Value = kvBuf[decoded.KeySize : decoded.KeySize+decoded.ValueSize]
+		}
+
+		data, err := entry.Encode()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+
+		offset += uint64(decoded.EntrySize)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// Positions change, so the old hint must go before the new file lands
+	if err := removeIfExists(db.getHintFilePathByID(fileID)); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	return syncDir(db.dbPath)
+}
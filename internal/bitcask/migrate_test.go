@@ -0,0 +1,230 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeLegacyStore writes two headerless data files: key1 and key2 in the
+// first one, then an overwrite of key1 and a legacy tombstone for key2.
+func writeLegacyStore(t *testing.T, dir string) {
+	var first, second []byte
+	first = append(first, encodeLegacyEntry(t, []byte("key1"), []byte("value1"))...)
+	first = append(first, encodeLegacyEntry(t, []byte("key2"), []byte("value2"))...)
+	second = append(second, encodeLegacyEntry(t, []byte("key1"), []byte("value3"))...)
+	second = append(second, encodeLegacyEntry(t, []byte("key2"), TombstoneValue)...)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.1.cask"), first, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.2.cask"), second, 0644))
+}
+
+func requireLegacyStoreContents(t *testing.T, db *Database) {
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value3", val)
+
+	_, ok, err = db.Get("key2")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestNewFilesStartWithHeader(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Close())
+
+	header, err := readSegmentHeaderByPath(filepath.Join(dir, "data.1.cask"))
+	require.NoError(t, err)
+	require.Equal(t, uint16(segmentFormatVersion), header.Version)
+	require.NotZero(t, header.CreatedAt)
+}
+
+func TestOpenRejectsUnknownVersion(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Close())
+
+	path := filepath.Join(dir, "data.1.cask")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	binary.LittleEndian.PutUint16(data[segmentVersionOffset:], segmentFormatVersion+1)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	err = db.Open()
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestOpenReadsLegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyStore(t, dir)
+
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	requireLegacyStoreContents(t, db)
+
+	// New writes go to a fresh file in the current format and survive a
+	// reopen
+	require.NoError(t, db.Set("key3", "value4"))
+	require.Equal(t, uint64(3), db.activeFileID)
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+
+	val, ok, err := db.Get("key3")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value4", val)
+}
+
+func TestMigrateRewritesLegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyStore(t, dir)
+
+	migrated, err := Migrate(dir)
+	require.NoError(t, err)
+	require.Equal(t, 2, migrated)
+
+	for _, name := range []string{"data.1.cask", "data.2.cask"} {
+		header, err := readSegmentHeaderByPath(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Equal(t, uint16(segmentFormatVersion), header.Version)
+	}
+
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	requireLegacyStoreContents(t, db)
+
+	// The legacy tombstone value became a flagged tombstone, so the bytes
+	// can now be stored as a value
+	require.NoError(t, db.Put([]byte("key2"), TombstoneValue))
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	got, ok, err := db.GetBytes([]byte("key2"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, TombstoneValue, got)
+
+	// Nothing is left to migrate
+	require.NoError(t, db.Close())
+	migrated, err = Migrate(dir)
+	require.NoError(t, err)
+	require.Zero(t, migrated)
+}
+
+func TestMigrateResumesAfterInterruption(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyStore(t, dir)
+
+	// Simulate a run that converted data.1 and died while writing data.2
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.migrateFile(1, segmentHeader{Version: legacySegmentVersion}))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.2.cask"+tmpFileSuffix), []byte("partial"), 0644))
+
+	migrated, err := Migrate(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	_, err = os.Stat(filepath.Join(dir, "data.2.cask"+tmpFileSuffix))
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+	requireLegacyStoreContents(t, db)
+}
+
+func TestMigrateDropsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	data := encodeLegacyEntry(t, []byte("key1"), []byte("value1"))
+	torn := encodeLegacyEntry(t, []byte("key2"), []byte("value2"))
+	data = append(data, torn[:len(torn)-3]...)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.1.cask"), data, 0644))
+
+	migrated, err := Migrate(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value1", val)
+
+	_, ok, err = db.Get("key2")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestMigrateFailsWhileDatabaseIsOpen(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyStore(t, dir)
+
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_, err := Migrate(dir)
+	require.ErrorIs(t, err, ErrDatabaseLocked)
+}
+
+// writeSecondsStore writes a version 1 data file, whose timestamps are in
+// seconds, holding key1.
+func writeSecondsStore(t *testing.T, dir string) {
+	data := segmentHeader{Version: 1, CreatedAt: 1694280000000000000}.Encode()
+	record, err := (&Entry{Timestamp: 1694280000, Key: []byte("key1"), Value: []byte("value1")}).Encode()
+	require.NoError(t, err)
+	data = append(data, record...)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.1.cask"), data, 0644))
+}
+
+func TestOpenConvertsSecondTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	writeSecondsStore(t, dir)
+
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+
+	require.Equal(t, uint64(1694280000*time.Second), db.keydir["key1"].Timestamp)
+	require.Equal(t, uint64(2), db.activeFileID)
+
+	require.NoError(t, db.Set("key1", "value2"))
+	versions, err := db.History("key1")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, time.Unix(1694280000, 0), versions[0].Timestamp)
+	require.True(t, versions[1].Timestamp.After(versions[0].Timestamp))
+	require.NoError(t, db.Close())
+
+	migrated, err := Migrate(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+
+	header, err := readSegmentHeaderByPath(filepath.Join(dir, "data.1.cask"))
+	require.NoError(t, err)
+	require.Equal(t, uint16(segmentFormatVersion), header.Version)
+
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	versions, err = db.History("key1")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, time.Unix(1694280000, 0), versions[0].Timestamp)
+	require.Equal(t, []byte("value1"), versions[0].Value)
+}
@@ -0,0 +1,150 @@
+package bitcask
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Every data file starts with a fixed header:
+// Magic | Version | Flags | CreatedAt
+// Files written before the header existed start right with their first
+// record and are read as format version 0. Version 2 records timestamps in
+// nanoseconds instead of seconds, and version 3 adds records whose value lives
+// in a blob file. The flags were reserved and zero before they were used, so
+// they need no new version.
+const (
+	segmentMagic         = "GCSK"
+	segmentFormatVersion = 3
+
+	segmentMagicSize     = 4
+	segmentVersionSize   = 2
+	segmentFlagsSize     = 2
+	segmentCreatedAtSize = 8
+)
+
+const segmentHeaderSize = segmentMagicSize + segmentVersionSize + segmentFlagsSize + segmentCreatedAtSize
+
+const segmentVersionOffset = segmentMagicSize
+const segmentFlagsOffset = segmentVersionOffset + segmentVersionSize
+const segmentCreatedAtOffset = segmentFlagsOffset + segmentFlagsSize
+
+// segmentFlagMerged marks a file written by a merge, so Open knows which
+// files the last merge already compacted.
+const segmentFlagMerged uint16 = 0x01
+
+const legacySegmentVersion = 0
+
+// nanoTimestampVersion is the first format version with timestamps in
+// nanoseconds.
+const nanoTimestampVersion = 2
+
+type segmentHeader struct {
+	Version   uint16
+	Flags     uint16
+	CreatedAt uint64 // Unix time in nanoseconds
+}
+
+// Merged reports whether a merge wrote the file.
+func (h segmentHeader) Merged() bool {
+	return h.Flags&segmentFlagMerged != 0
+}
+
+// DataStart is the offset of the first record in the file.
+func (h segmentHeader) DataStart() uint64 {
+	if h.Version == legacySegmentVersion {
+		return 0
+	}
+	return segmentHeaderSize
+}
+
+// Timestamp converts a record timestamp read from the file to nanoseconds.
+func (h segmentHeader) Timestamp(raw uint64) uint64 {
+	if h.Version < nanoTimestampVersion {
+		return raw * uint64(time.Second)
+	}
+	return raw
+}
+
+func newSegmentHeader() segmentHeader {
+	return segmentHeader{
+		Version:   segmentFormatVersion,
+		CreatedAt: uint64(time.Now().UnixNano()),
+	}
+}
+
+func (h segmentHeader) Encode() []byte {
+	buf := make([]byte, segmentHeaderSize)
+	copy(buf, segmentMagic)
+	binary.LittleEndian.PutUint16(buf[segmentVersionOffset:], h.Version)
+	binary.LittleEndian.PutUint16(buf[segmentFlagsOffset:], h.Flags)
+	binary.LittleEndian.PutUint64(buf[segmentCreatedAtOffset:], h.CreatedAt)
+	return buf
+}
+
+// readSegmentHeader reads the header at the start of f. Files without the
+// magic number are legacy files, and versions newer than this code can read
+// are rejected.
+func readSegmentHeader(f *os.File) (segmentHeader, error) {
+	buf := make([]byte, segmentHeaderSize)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return segmentHeader{}, fmt.Errorf("failed to read header of %s: %w", f.Name(), err)
+	}
+
+	if n < segmentHeaderSize || !bytes.Equal(buf[:segmentMagicSize], []byte(segmentMagic)) {
+		return segmentHeader{Version: legacySegmentVersion}, nil
+	}
+
+	h := segmentHeader{
+		Version:   binary.LittleEndian.Uint16(buf[segmentVersionOffset:]),
+		Flags:     binary.LittleEndian.Uint16(buf[segmentFlagsOffset:]),
+		CreatedAt: binary.LittleEndian.Uint64(buf[segmentCreatedAtOffset:]),
+	}
+
+	if h.Version == legacySegmentVersion || h.Version > segmentFormatVersion {
+		return segmentHeader{}, fmt.Errorf("%w: %s has format version %d, this build reads up to %d",
+			ErrUnsupportedVersion, f.Name(), h.Version, segmentFormatVersion)
+	}
+
+	return h, nil
+}
+
+func readSegmentHeaderByPath(path string) (segmentHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return segmentHeader{}, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	return readSegmentHeader(f)
+}
+
+// createSegmentFile atomically creates a data file holding only a fresh
+// header, so a crash never leaves a file with a partial header behind.
+func createSegmentFile(path string, mode os.FileMode) error {
+	tmpPath := path + tmpFileSuffix
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(newSegmentHeader().Encode()); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
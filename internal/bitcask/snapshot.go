@@ -0,0 +1,217 @@
+package bitcask
+
+import (
+	"fmt"
+	"iter"
+	"os"
+	"time"
+)
+
+// pinnedState is the keydir and data files as they were at one moment. It
+// keeps the file handles it references open, so the values stay readable
+// even after merge replaces or removes their files.
+type pinnedState struct {
+	root  *indexNode
+	files map[uint64]*os.File
+	now   time.Time // expiry is judged as of this moment
+	dir   string    // the database directory, which holds the blob files
+}
+
+// pin captures the current keydir and data files. The caller must hold mu
+// exclusively and call unpin once done with the state.
+func (db *Database) pin() *pinnedState {
+	files := make(map[uint64]*os.File, len(db.files))
+	for id, f := range db.files {
+		files[id] = f
+		db.fileRefs[f]++
+	}
+
+	return &pinnedState{root: db.index, files: files, now: time.Now(), dir: db.dbPath}
+}
+
+// unpin releases a state and closes the files that merge retired while it
+// was pinned and that no other state still needs. The caller must hold mu
+// exclusively.
+func (db *Database) unpin(p *pinnedState) {
+	for _, f := range p.files {
+		db.fileRefs[f]--
+		if db.fileRefs[f] > 0 {
+			continue
+		}
+
+		delete(db.fileRefs, f)
+		if _, ok := db.retired[f]; ok {
+			delete(db.retired, f)
+			_ = f.Close()
+		}
+	}
+
+	db.removeOrphanBlobs()
+}
+
+// retireFile closes a file that merge no longer needs, or keeps it open
+// until every state pinning it is released. The caller must hold mu
+// exclusively.
+func (db *Database) retireFile(f *os.File) {
+	if db.fileRefs[f] > 0 {
+		db.retired[f] = struct{}{}
+		return
+	}
+
+	_ = f.Close()
+}
+
+// lookup returns the keydir entry of key in the state, leaving out expired
+// keys.
+func (p *pinnedState) lookup(key string) (KeydirEntry, bool) {
+	n := indexFind(p.root, key)
+	if n == nil || n.meta.expired(p.now) {
+		return KeydirEntry{}, false
+	}
+
+	return n.meta, true
+}
+
+// readValue reads a value from the files of the state, following blob
+// references to their blob file.
+func (p *pinnedState) readValue(meta KeydirEntry) ([]byte, error) {
+	value, err := p.readRecordValue(meta)
+	if err != nil || !meta.Blob {
+		return value, err
+	}
+
+	return readBlob(p.dir, value)
+}
+
+// readRecordValue reads the value as the record holds it from the files of
+// the state.
+func (p *pinnedState) readRecordValue(meta KeydirEntry) ([]byte, error) {
+	f, ok := p.files[meta.FileID]
+	if !ok {
+		return nil, fmt.Errorf("no open db file with ID %d", meta.FileID)
+	}
+
+	value := make([]byte, meta.ValueSize)
+	if _, err := f.ReadAt(value, int64(meta.ValuePos)); err != nil {
+		return nil, fmt.Errorf("failed to read value: %w", err)
+	}
+
+	return value, nil
+}
+
+// Snapshot is a read-only view of the database as it was when Snapshot was
+// called. Writes made afterwards are not visible through it, and merge keeps
+// the data it needs readable until it is released.
+type Snapshot struct {
+	db       *Database
+	state    *pinnedState
+	released bool
+}
+
+// Snapshot pins the current state of the database. The snapshot must be
+// released once done with, so merge can reclaim the files it holds on to.
+func (db *Database) Snapshot() (*Snapshot, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(db.files) == 0 {
+		return nil, ErrClosed
+	}
+
+	return &Snapshot{db: db, state: db.pin()}, nil
+}
+
+func (s *Snapshot) Get(key string) (string, bool, error) {
+	value, exists, err := s.GetBytes([]byte(key))
+	if err != nil || !exists {
+		return "", false, err
+	}
+
+	return string(value), true, nil
+}
+
+// GetBytes returns the value key had when the snapshot was taken.
+func (s *Snapshot) GetBytes(key []byte) ([]byte, bool, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	if s.released {
+		return nil, false, ErrSnapshotReleased
+	}
+
+	meta, exists := s.state.lookup(string(key))
+	if !exists {
+		return nil, false, nil
+	}
+
+	value, err := s.state.readValue(meta)
+	if err != nil {
+		return nil, false, err
+	}
+	s.db.metrics.Count(MetricReads, 1)
+
+	return value, true, nil
+}
+
+// All returns a sequence of every key in the snapshot and its value, see
+// Database.All.
+func (s *Snapshot) All() iter.Seq2[[]byte, []byte] {
+	return pairSeq(func() *Iterator { return s.Iterator("", "") })
+}
+
+// Keys returns a sequence of every key in the snapshot, without reading any
+// value.
+func (s *Snapshot) Keys() iter.Seq[[]byte] {
+	return keySeq(func() *Iterator { return s.Iterator("", "") })
+}
+
+// Scan returns a sequence of the keys in the snapshot from start up to, but
+// not including, end, and their values. An empty end scans to the last key.
+func (s *Snapshot) Scan(start string, end string) iter.Seq2[[]byte, []byte] {
+	return pairSeq(func() *Iterator { return s.Iterator(start, end) })
+}
+
+// Prefix returns a sequence of the keys in the snapshot that start with
+// prefix and their values.
+func (s *Snapshot) Prefix(prefix string) iter.Seq2[[]byte, []byte] {
+	return pairSeq(func() *Iterator { return s.PrefixIterator(prefix) })
+}
+
+// Iterator returns an iterator over the keys in the snapshot from start up
+// to, but not including, end. An empty end iterates to the last key.
+func (s *Snapshot) Iterator(start string, end string) *Iterator {
+	return s.newIterator(start, end, "")
+}
+
+// PrefixIterator returns an iterator over the keys in the snapshot that
+// start with prefix.
+func (s *Snapshot) PrefixIterator(prefix string) *Iterator {
+	return s.newIterator(prefix, "", prefix)
+}
+
+func (s *Snapshot) newIterator(start, end, prefix string) *Iterator {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	if s.released {
+		return &Iterator{db: s.db, err: ErrSnapshotReleased, closed: true}
+	}
+
+	it := &Iterator{db: s.db, state: s.state, snap: s, end: end, prefix: prefix}
+	it.cursor.seek(s.state.root, start)
+	return it
+}
+
+// Release unpins the snapshot. It is safe to call more than once. Iterators
+// created from the snapshot must not be used afterwards.
+func (s *Snapshot) Release() {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if s.released {
+		return
+	}
+	s.released = true
+
+	s.db.unpin(s.state)
+}
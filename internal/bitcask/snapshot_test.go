@@ -0,0 +1,113 @@
+package bitcask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotIsStableDuringWrites(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Set("key2", "value2"))
+
+	snap, err := db.Snapshot()
+	require.NoError(t, err)
+	defer snap.Release()
+
+	require.NoError(t, db.Set("key1", "changed"))
+	require.NoError(t, db.Delete("key2"))
+	require.NoError(t, db.Set("key3", "added"))
+
+	val, ok, err := snap.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value1", val)
+
+	val, ok, err = snap.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value2", val)
+
+	_, ok, err = snap.Get("key3")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.Equal(t, []string{"key1", "key2"}, collectKeys(t, snap.Iterator("", "")))
+	require.Equal(t, []string{"key2"}, collectKeys(t, snap.Iterator("key2", "")))
+	require.Equal(t, []string{"key1", "key3"}, collectKeys(t, db.Iterator("", "")))
+
+	// Closing an iterator leaves the snapshot pinned
+	val, ok, err = snap.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value2", val)
+}
+
+func TestSnapshotSurvivesMerge(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1")) // data.1
+	require.NoError(t, db.Set("key2", "value2")) // data.1
+
+	snap, err := db.Snapshot()
+	require.NoError(t, err)
+
+	require.NoError(t, db.Set("key1", "value3")) // data.2
+	require.NoError(t, db.Delete("key2"))        // data.2
+	require.NoError(t, db.Set("key4", "value5")) // data.3 (active)
+
+	// The merge rewrites data.1 without either value the snapshot sees
+	require.NoError(t, db.Merge())
+	require.NotEmpty(t, db.retired)
+
+	got := make(map[string]string)
+	for key, value := range snap.All() {
+		got[string(key)] = string(value)
+	}
+	require.Equal(t, map[string]string{"key1": "value1", "key2": "value2"}, got)
+
+	snap.Release()
+	require.Empty(t, db.retired)
+	require.Empty(t, db.fileRefs)
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value3", val)
+}
+
+func TestSnapshotRelease(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1"))
+
+	snap, err := db.Snapshot()
+	require.NoError(t, err)
+
+	it := snap.Iterator("", "")
+	require.True(t, it.Next())
+
+	snap.Release()
+	snap.Release()
+
+	_, _, err = snap.Get("key1")
+	require.ErrorIs(t, err, ErrSnapshotReleased)
+
+	_, err = it.Value()
+	require.ErrorIs(t, err, ErrSnapshotReleased)
+
+	it = snap.Iterator("", "")
+	require.False(t, it.Next())
+	require.ErrorIs(t, it.Err(), ErrSnapshotReleased)
+}
@@ -0,0 +1,124 @@
+package bitcask
+
+import (
+	"fmt"
+	"time"
+)
+
+// SyncPolicy decides when writes are flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncNever leaves flushing to the operating system. This is the
+	// default.
+	SyncNever SyncPolicy = iota
+
+	// SyncAlways syncs the active file before every write returns.
+	SyncAlways
+
+	// SyncInterval syncs the active file in the background on a fixed
+	// interval, so a crash loses at most one interval of writes.
+	SyncInterval
+)
+
+const defaultSyncInterval = time.Second
+
+// SetSyncPolicy chooses when writes are synced to disk. The interval is only
+// used by SyncInterval and defaults to one second when zero. It must be
+// called before Open.
+func (db *Database) SetSyncPolicy(policy SyncPolicy, interval time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if interval <= 0 {
+		interval = defaultSyncInterval
+	}
+
+	db.syncPolicy = policy
+	db.syncInterval = interval
+}
+
+// Sync flushes every write made so far to stable storage.
+func (db *Database) Sync() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if len(db.files) == 0 {
+		return ErrClosed
+	}
+
+	return db.syncActiveFile()
+}
+
+// syncActiveFile syncs the active file. The caller must hold mu.
+func (db *Database) syncActiveFile() error {
+	if db.readOnly || db.activeFile == nil {
+		return nil
+	}
+
+	db.dirty.Store(false)
+
+	if err := db.activeFile.Sync(); err != nil {
+		db.dirty.Store(true)
+		return fmt.Errorf("failed to sync active file: %w", err)
+	}
+	db.metrics.Count(MetricSyncs, 1)
+
+	return nil
+}
+
+// startSyncer starts the background sync loop of SyncInterval. The caller
+// must hold mu.
+func (db *Database) startSyncer() {
+	if db.readOnly || db.syncPolicy != SyncInterval {
+		return
+	}
+
+	stop := make(chan struct{})
+	db.stopSyncer = stop
+	db.syncerWG.Add(1)
+
+	go func() {
+		defer db.syncerWG.Done()
+
+		ticker := time.NewTicker(db.syncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if !db.dirty.Load() {
+					continue
+				}
+				if err := db.Sync(); err != nil {
+					db.logger.Warn("background sync failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// stopBackgroundSyncer stops the loop started by startSyncer and waits for it
+// to exit. It must be called without holding mu.
+func (db *Database) stopBackgroundSyncer() {
+	db.mu.Lock()
+	stop := db.stopSyncer
+	db.stopSyncer = nil
+	db.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		db.syncerWG.Wait()
+	}
+}
+
+// syncDir syncs a directory so the files created, renamed or removed in it
+// survive a crash.
+func syncDir(path string) error {
+	if err := syncDirectory(path); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package bitcask
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncAlwaysLeavesNothingUnsynced(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	db.SetSyncPolicy(SyncAlways, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("foo", "bar"))
+	require.False(t, db.dirty.Load())
+
+	require.NoError(t, db.Delete("foo"))
+	require.False(t, db.dirty.Load())
+}
+
+func TestSyncNeverWaitsForExplicitSync(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("foo", "bar"))
+	require.True(t, db.dirty.Load())
+
+	require.NoError(t, db.Sync())
+	require.False(t, db.dirty.Load())
+}
+
+func TestSyncIntervalSyncsInBackground(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	db.SetSyncPolicy(SyncInterval, 10*time.Millisecond)
+	require.NoError(t, db.Open())
+
+	require.NoError(t, db.Set("foo", "bar"))
+	require.Eventually(t, func() bool {
+		return !db.dirty.Load()
+	}, time.Second, 5*time.Millisecond)
+
+	// Close stops the background loop
+	require.NoError(t, db.Close())
+	require.Nil(t, db.stopSyncer)
+
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+	require.NotNil(t, db.stopSyncer)
+
+	val, ok, err := db.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", val)
+}
+
+func TestRotationSyncsPreviousFile(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70) // 70 bytes
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_ = db.Set("key1", "value1") // 30 bytes
+	_ = db.Set("key2", "value2") // 30 bytes
+	require.True(t, db.dirty.Load())
+
+	// Rotation syncs data.1 even though the policy never syncs, and only
+	// the write to the new file is left pending
+	require.NoError(t, db.Set("key3", "value3"))
+	require.Equal(t, uint64(2), db.activeFileID)
+	require.True(t, db.dirty.Load())
+}
+
+func TestSyncOnReadOnlyDatabaseIsNoop(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Set("foo", "bar"))
+	require.NoError(t, db.Close())
+
+	db.SetSyncPolicy(SyncInterval, 10*time.Millisecond)
+	require.NoError(t, db.OpenReadOnly())
+	defer func() { _ = db.Close() }()
+
+	require.Nil(t, db.stopSyncer)
+	require.NoError(t, db.Sync())
+}
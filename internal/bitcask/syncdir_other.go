@@ -0,0 +1,10 @@
+//go:build !unix
+
+package bitcask
+
+// Directories cannot be synced on every platform, so outside unix systems the
+// directory entries are left to the operating system.
+
+func syncDirectory(_ string) error {
+	return nil
+}
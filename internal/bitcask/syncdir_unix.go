@@ -0,0 +1,19 @@
+//go:build unix
+
+package bitcask
+
+import "os"
+
+func syncDirectory(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+
+	return d.Close()
+}
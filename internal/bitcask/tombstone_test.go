@@ -0,0 +1,140 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// encodeLegacyEntry encodes a record the way it was written before records
+// had flags.
+func encodeLegacyEntry(t *testing.T, key, value []byte) []byte {
+	data, err := (&Entry{Timestamp: 1694280000, Key: key, Value: value}).Encode()
+	require.NoError(t, err)
+
+	binary.LittleEndian.PutUint32(data[keySizeOffset:keySizeEnd], uint32(len(key)))
+	binary.LittleEndian.PutUint32(data[crcOffset:crcEnd], crc32.ChecksumIEEE(data[crcEnd:]))
+
+	return data
+}
+
+func TestTombstoneValueIsAnOrdinaryValue(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+
+	require.NoError(t, db.Put([]byte("magic"), TombstoneValue))
+
+	got, ok, err := db.GetBytes([]byte("magic"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, TombstoneValue, got)
+
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	got, ok, err = db.GetBytes([]byte("magic"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, TombstoneValue, got)
+}
+
+func TestDeleteDropsKeyFromKeydir(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+
+	require.NoError(t, db.Set("foo", "bar"))
+	require.NoError(t, db.Delete("foo"))
+	require.NotContains(t, db.keydir, "foo")
+
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NotContains(t, db.keydir, "foo")
+}
+
+func TestTombstonesSurviveInHintFiles(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70) // 70 bytes
+	require.NoError(t, db.Open())
+
+	_ = db.Set("key1", "value1") // data.1
+	_ = db.Set("key2", "value2") // data.1
+	_ = db.Delete("key1")        // data.2
+	_ = db.Set("key3", "value3") // data.2
+	_ = db.Set("key4", "value4") // data.3, data.2 gets its hint
+	require.NoError(t, db.Close())
+
+	data, err := os.ReadFile(filepath.Join(dir, "data.2.hint"))
+	require.NoError(t, err)
+	hints, err := decodeHints(data, 2, 1024)
+	require.NoError(t, err)
+	require.Len(t, hints, 2)
+	require.Equal(t, "key1", hints[0].key)
+	require.True(t, hints[0].tombstone)
+
+	db = NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	val, ok, err := db.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value2", val)
+}
+
+func TestOpenReadsLegacyRecords(t *testing.T) {
+	dir := t.TempDir()
+
+	var data []byte
+	data = append(data, encodeLegacyEntry(t, []byte("key1"), []byte("value1"))...)
+	data = append(data, encodeLegacyEntry(t, []byte("key2"), []byte("value2"))...)
+	data = append(data, encodeLegacyEntry(t, []byte("key1"), TombstoneValue)...)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.1.cask"), data, 0644))
+
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NotContains(t, db.keydir, "key1")
+
+	val, ok, err := db.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value2", val)
+
+	// New records are appended to the legacy file with flags
+	require.NoError(t, db.Put([]byte("key3"), TombstoneValue))
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+
+	got, ok, err := db.GetBytes([]byte("key3"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, TombstoneValue, got)
+}
+
+func TestDecodeHintsRejectsLegacyHints(t *testing.T) {
+	data := encodeHint(hintEntry{key: "key", meta: KeydirEntry{FileID: 1, ValuePos: 24, ValueSize: 4}})
+
+	// Clear the flags the way hints were written before tombstone flags
+	binary.LittleEndian.PutUint32(data[hintKeySizeOffset:], 3)
+	binary.LittleEndian.PutUint32(data[crcOffset:crcEnd], crc32.ChecksumIEEE(data[crcEnd:]))
+
+	_, err := decodeHints(data, 1, 1024)
+	require.Error(t, err)
+}
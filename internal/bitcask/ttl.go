@@ -0,0 +1,143 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const defaultSweepInterval = time.Minute
+
+// SetWithTTL stores value under key and makes it expire after ttl.
+func (db *Database) SetWithTTL(key string, value string, ttl time.Duration) error {
+	return db.PutWithTTL([]byte(key), []byte(value), ttl)
+}
+
+// PutWithTTL works like Put and makes the value expire after ttl. Once it
+// expires the key reads as missing, as if it had been deleted.
+func (db *Database) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid ttl %v: must be positive", ttl)
+	}
+
+	entry := NewEntryBytes(key, value)
+	entry.ExpiresAt = uint64(time.Now().Add(ttl).UnixNano())
+
+	return db.write(entry)
+}
+
+// TTL returns how long key has left before it expires. The duration is zero
+// for keys that never expire.
+func (db *Database) TTL(key string) (time.Duration, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now()
+	meta, err := db.lookup(key, now)
+	if errors.Is(err, ErrNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	if meta.ExpiresAt == 0 {
+		return 0, true, nil
+	}
+
+	return time.Duration(meta.ExpiresAt - uint64(now.UnixNano())), true, nil
+}
+
+// SetSweepInterval chooses how often expired keys are evicted from memory.
+// Expired keys read as missing right away, the sweep only frees their keydir
+// entries. The interval defaults to one minute when zero. It must be called
+// before Open.
+func (db *Database) SetSweepInterval(interval time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+
+	db.sweepInterval = interval
+}
+
+// evictExpired removes the keys that expired at now from the keydir. Expired
+// keys in the active file are kept as tombstones so its hint still hides
+// older values of them.
+func (db *Database) evictExpired(now time.Time) int {
+	// Look for expired keys first so the write lock is only taken when there
+	// is something to evict
+	db.mu.RLock()
+	var expired []string
+	for key, meta := range db.keydir {
+		if meta.expired(now) {
+			expired = append(expired, key)
+		}
+	}
+	db.mu.RUnlock()
+
+	if len(expired) == 0 {
+		return 0
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	evicted := 0
+	for _, key := range expired {
+		// The key may have been written again since it was found
+		meta, ok := db.keydir[key]
+		if !ok || !meta.expired(now) {
+			continue
+		}
+
+		db.deleteKeydir(key)
+		if meta.FileID == db.activeFileID {
+			db.tombstones[key] = meta
+		}
+		evicted++
+	}
+	db.metrics.Count(MetricExpired, int64(evicted))
+
+	return evicted
+}
+
+// startSweeper starts the background loop that evicts expired keys. The
+// caller must hold mu.
+func (db *Database) startSweeper() {
+	stop := make(chan struct{})
+	db.stopSweeper = stop
+	db.sweeperWG.Add(1)
+
+	go func() {
+		defer db.sweeperWG.Done()
+
+		ticker := time.NewTicker(db.sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				db.evictExpired(now)
+			}
+		}
+	}()
+}
+
+// stopBackgroundSweeper stops the loop started by startSweeper and waits for
+// it to exit. It must be called without holding mu.
+func (db *Database) stopBackgroundSweeper() {
+	db.mu.Lock()
+	stop := db.stopSweeper
+	db.stopSweeper = nil
+	db.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		db.sweeperWG.Wait()
+	}
+}
@@ -0,0 +1,171 @@
+package bitcask
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryWithExpiryRoundTrip(t *testing.T) {
+	e := &Entry{Timestamp: 1694280000, Key: []byte("key"), Value: []byte("value"), ExpiresAt: 1694280060000000000}
+	data, err := e.Encode()
+	require.NoError(t, err)
+	require.Len(t, data, headerSize+len("key")+len("value")+expiresAtSize)
+
+	decoded, _, err := decodeNextRecord(bytes.NewReader(data), uint64(len(data)))
+	require.NoError(t, err)
+	require.Equal(t, e.ExpiresAt, decoded.ExpiresAt)
+	require.Equal(t, uint32(len(data)), decoded.EntrySize)
+	require.Equal(t, uint32(len("value")), decoded.ValueSize)
+}
+
+func TestSetWithTTLExpires(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.SetWithTTL("session", "abc", 100*time.Millisecond))
+	require.NoError(t, db.Set("user", "sirius"))
+
+	val, ok, err := db.Get("session")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "abc", val)
+
+	ttl, ok, err := db.TTL("session")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Greater(t, ttl, time.Duration(0))
+	require.LessOrEqual(t, ttl, 100*time.Millisecond)
+
+	ttl, ok, err = db.TTL("user")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Zero(t, ttl)
+
+	time.Sleep(150 * time.Millisecond)
+
+	_, ok, err = db.Get("session")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, ok, err = db.TTL("session")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestSetWithTTLRejectsNonPositiveTTL(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.Error(t, db.SetWithTTL("key", "value", 0))
+	require.Error(t, db.SetWithTTL("key", "value", -time.Second))
+}
+
+func TestExpiryPersistsAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Set("key2", "value2"))
+	require.NoError(t, db.SetWithTTL("key1", "value3", 100*time.Millisecond)) // data.2
+	require.NoError(t, db.SetWithTTL("key2", "value4", time.Hour))            // data.3
+	require.NoError(t, db.Set("key3", "value5"))                              // data.3 (active)
+	require.NoError(t, db.Close())
+
+	// data.2 is loaded from its hint, which must keep the expiry times
+	require.FileExists(t, db.getHintFilePathByID(2))
+	time.Sleep(150 * time.Millisecond)
+
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	// The expired value hides the older one instead of bringing it back
+	_, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	val, ok, err := db.Get("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value4", val)
+
+	ttl, ok, err := db.TTL("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Greater(t, ttl, 59*time.Minute)
+}
+
+func TestMergeDropsExpiredKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+
+	require.NoError(t, db.Set("key1", "value1"))                              // data.1
+	require.NoError(t, db.SetWithTTL("key1", "value2", 100*time.Millisecond)) // data.1
+	require.NoError(t, db.SetWithTTL("key2", "value3", time.Hour))            // data.2
+	require.NoError(t, db.Set("key3", "value4"))                              // data.2
+	require.NoError(t, db.Set("key4", "value5"))                              // data.3 (active)
+	time.Sleep(150 * time.Millisecond)
+
+	require.NoError(t, db.Merge())
+
+	_, ok := db.keydir["key1"]
+	require.False(t, ok)
+
+	ttl, ok, err := db.TTL("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Greater(t, ttl, 59*time.Minute)
+
+	// Neither the expired value nor the one it replaced come back
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_, ok, err = db.Get("key1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ttl, ok, err = db.TTL("key2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Greater(t, ttl, 59*time.Minute)
+}
+
+func TestSweeperEvictsExpiredKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	db.SetSweepInterval(20 * time.Millisecond)
+	require.NoError(t, db.Open())
+
+	require.NoError(t, db.Set("key1", "value1"))                             // data.1
+	require.NoError(t, db.Set("key0", "value0"))                             // data.1
+	require.NoError(t, db.SetWithTTL("key1", "value2", 50*time.Millisecond)) // data.2
+	require.NoError(t, db.Set("key2", "value3"))                             // data.2
+
+	require.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		_, ok := db.keydir["key1"]
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	// Rotate so data.2 gets its hint after the key was evicted
+	require.NoError(t, db.Set("key3", "value4"))
+	require.FileExists(t, db.getHintFilePathByID(2))
+	require.NoError(t, db.Close())
+
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	_, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,219 @@
+package bitcask
+
+// view tracks the keys written since it began, which is what a transaction
+// needs to detect conflicts. The values it reads come from a pinned state.
+type view struct {
+	changed map[string]struct{} // keys written since the view began
+}
+
+// openView starts tracking writes. The caller must hold mu exclusively.
+func (db *Database) openView() *view {
+	v := &view{changed: make(map[string]struct{})}
+	db.views[v] = struct{}{}
+	return v
+}
+
+// closeView stops tracking writes. The caller must hold mu exclusively.
+func (db *Database) closeView(v *view) {
+	delete(db.views, v)
+}
+
+// recordChange marks key as written in every open view. The caller must hold
+// mu exclusively.
+func (db *Database) recordChange(key string) {
+	for v := range db.views {
+		v.changed[key] = struct{}{}
+	}
+}
+
+// Txn is a transaction started by Database.View or Database.Update. It reads
+// the keydir as it was when the transaction began, plus its own writes, which
+// are buffered until it commits. A Txn must not be used after the function
+// it was passed to returns.
+type Txn struct {
+	db       *Database
+	view     *view
+	state    *pinnedState
+	writable bool
+	closed   bool
+
+	batch  Batch
+	writes map[string]*Entry   // latest buffered write of each key
+	reads  map[string]struct{} // keys read from the view
+}
+
+// View runs fn in a read-only transaction.
+func (db *Database) View(fn func(tx *Txn) error) error {
+	tx, err := db.begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.close()
+
+	return fn(tx)
+}
+
+// Update runs fn in a read-write transaction and commits its writes when fn
+// returns nil. The commit fails with ErrConflict if another writer changed a
+// key the transaction read, in which case nothing is written. The writes are
+// appended as one batch, so the transaction is durable exactly when its
+// commit marker is.
+func (db *Database) Update(fn func(tx *Txn) error) error {
+	tx, err := db.begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.close()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.commit()
+}
+
+func (db *Database) begin(writable bool) (*Txn, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if writable && db.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if len(db.files) == 0 {
+		return nil, ErrClosed
+	}
+
+	return &Txn{
+		db:       db,
+		view:     db.openView(),
+		state:    db.pin(),
+		writable: writable,
+		writes:   make(map[string]*Entry),
+		reads:    make(map[string]struct{}),
+	}, nil
+}
+
+func (tx *Txn) close() {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+
+	tx.db.closeView(tx.view)
+	tx.db.unpin(tx.state)
+	tx.closed = true
+}
+
+func (tx *Txn) commit() error {
+	// Blob files are written before taking the lock, like any other write
+	entries, blobIDs, err := tx.db.storeBlobs(tx.batch.entries)
+	if err != nil {
+		return err
+	}
+	defer tx.db.releaseBlobs(blobIDs)
+
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+
+	for key := range tx.reads {
+		if _, ok := tx.view.changed[key]; ok {
+			tx.db.removeBlobs(blobIDs)
+			return ErrConflict
+		}
+	}
+
+	if tx.batch.Len() == 0 {
+		return nil
+	}
+
+	return tx.db.appendEntries([][]*Entry{entries})
+}
+
+func (tx *Txn) Get(key string) (string, bool, error) {
+	value, exists, err := tx.GetBytes([]byte(key))
+	if err != nil || !exists {
+		return "", false, err
+	}
+
+	return string(value), true, nil
+}
+
+// GetBytes returns the value of key as of the start of the transaction, or
+// the value the transaction itself wrote.
+func (tx *Txn) GetBytes(key []byte) ([]byte, bool, error) {
+	if tx.closed {
+		return nil, false, ErrTxnClosed
+	}
+
+	if entry, ok := tx.writes[string(key)]; ok {
+		if entry.Tombstone {
+			return nil, false, nil
+		}
+		return entry.Value, true, nil
+	}
+
+	tx.reads[string(key)] = struct{}{}
+
+	tx.db.mu.RLock()
+	defer tx.db.mu.RUnlock()
+
+	meta, exists := tx.state.lookup(string(key))
+	if !exists {
+		return nil, false, nil
+	}
+
+	value, err := tx.state.readValue(meta)
+	if err != nil {
+		return nil, false, err
+	}
+	tx.db.metrics.Count(MetricReads, 1)
+
+	return value, true, nil
+}
+
+func (tx *Txn) Set(key string, value string) error {
+	return tx.Put([]byte(key), []byte(value))
+}
+
+// Put buffers a write of value under key until the transaction commits. Both
+// slices must not be modified until then.
+func (tx *Txn) Put(key []byte, value []byte) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	entry := NewEntryBytes(key, value)
+	if err := tx.db.checkEntry(entry); err != nil {
+		return err
+	}
+
+	tx.batch.entries = append(tx.batch.entries, entry)
+	tx.writes[string(key)] = entry
+	return nil
+}
+
+// Delete buffers a deletion of key until the transaction commits. Like Put,
+// it takes the key as bytes, which must not be modified until then.
+func (tx *Txn) Delete(key []byte) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+
+	entry := NewTombstone(key)
+	if err := tx.db.checkEntry(entry); err != nil {
+		return err
+	}
+
+	tx.batch.entries = append(tx.batch.entries, entry)
+	tx.writes[string(key)] = entry
+	return nil
+}
+
+func (tx *Txn) checkWritable() error {
+	if tx.closed {
+		return ErrTxnClosed
+	}
+	if !tx.writable {
+		return ErrReadOnly
+	}
+	return nil
+}
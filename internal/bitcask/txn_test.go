@@ -0,0 +1,268 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateCommitsWrites(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+
+	require.NoError(t, db.Set("stale", "value"))
+
+	err := db.Update(func(tx *Txn) error {
+		require.NoError(t, tx.Set("doc", "body"))
+		require.NoError(t, tx.Set("index", "doc"))
+		require.NoError(t, tx.Delete([]byte("stale")))
+
+		// The transaction reads its own writes before they are committed
+		val, ok, err := tx.Get("doc")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "body", val)
+
+		_, ok, err = tx.Get("stale")
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, ok, err = db.Get("doc")
+		require.NoError(t, err)
+		require.False(t, ok)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	val, ok, err := db.Get("index")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "doc", val)
+
+	_, ok, err = db.Get("stale")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestUpdateDiscardsWritesOnError(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	errAbort := errors.New("abort")
+	err := db.Update(func(tx *Txn) error {
+		require.NoError(t, tx.Set("key1", "value1"))
+		return errAbort
+	})
+	require.ErrorIs(t, err, errAbort)
+
+	_, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestViewReadsSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1"))
+	require.NoError(t, db.Set("key2", "value2"))
+
+	err := db.View(func(tx *Txn) error {
+		require.NoError(t, db.Set("key1", "changed"))
+		require.NoError(t, db.Delete("key2"))
+		require.NoError(t, db.Set("key3", "added"))
+
+		val, ok, err := tx.Get("key1")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "value1", val)
+
+		val, ok, err = tx.Get("key2")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "value2", val)
+
+		_, ok, err = tx.Get("key3")
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.ErrorIs(t, tx.Set("key4", "value4"), ErrReadOnly)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Empty(t, db.views)
+}
+
+func TestUpdateConflict(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("balance", "10"))
+
+	err := db.Update(func(tx *Txn) error {
+		_, _, err := tx.Get("balance")
+		require.NoError(t, err)
+
+		require.NoError(t, db.Set("balance", "20"))
+
+		require.NoError(t, tx.Set("balance", "11"))
+		require.NoError(t, tx.Set("audit", "deposit"))
+		return nil
+	})
+	require.ErrorIs(t, err, ErrConflict)
+
+	val, ok, err := db.Get("balance")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "20", val)
+
+	_, ok, err = db.Get("audit")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestUpdateBlindWriteDoesNotConflict(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	err := db.Update(func(tx *Txn) error {
+		require.NoError(t, db.Set("key1", "other"))
+		return tx.Set("key1", "mine")
+	})
+	require.NoError(t, err)
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "mine", val)
+}
+
+func TestTxnUsedAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	var leaked *Txn
+	require.NoError(t, db.Update(func(tx *Txn) error {
+		leaked = tx
+		return nil
+	}))
+
+	_, _, err := leaked.Get("key1")
+	require.ErrorIs(t, err, ErrTxnClosed)
+	require.ErrorIs(t, leaked.Set("key1", "value1"), ErrTxnClosed)
+}
+
+func TestUpdateReadOnlyDatabase(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 0)
+	require.NoError(t, db.Open())
+	require.NoError(t, db.Close())
+
+	require.NoError(t, db.OpenReadOnly())
+	defer func() { _ = db.Close() }()
+
+	err := db.Update(func(tx *Txn) error { return nil })
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.NoError(t, db.View(func(tx *Txn) error { return nil }))
+}
+
+func TestConcurrentIncrementsWithRetry(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 256)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("counter", "0"))
+
+	const workers = 8
+	const increments = 25
+
+	increment := func(tx *Txn) error {
+		val, _, err := tx.Get("counter")
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		return tx.Set("counter", strconv.Itoa(n+1))
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				for {
+					err := db.Update(increment)
+					if errors.Is(err, ErrConflict) {
+						continue
+					}
+					assert.NoError(t, err)
+					break
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, ok, err := db.Get("counter")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, fmt.Sprint(workers*increments), val)
+}
+
+func TestMergeDuringTransaction(t *testing.T) {
+	dir := t.TempDir()
+	db := NewDatabase(dir, 70)
+	require.NoError(t, db.Open())
+	defer func() { _ = db.Close() }()
+
+	require.NoError(t, db.Set("key1", "value1")) // data.1
+	require.NoError(t, db.Set("key2", "value2")) // data.1
+
+	err := db.View(func(tx *Txn) error {
+		// Overwrite key1 so the merge drops the value the view still sees
+		require.NoError(t, db.Set("key1", "value3")) // data.2
+		require.NoError(t, db.Set("key3", "value4")) // data.2
+		require.NoError(t, db.Set("key4", "value5")) // data.3 (active)
+
+		require.NoError(t, db.Merge())
+
+		val, ok, err := tx.Get("key1")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "value1", val)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, db.retired)
+
+	val, ok, err := db.Get("key1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "value3", val)
+}
@@ -0,0 +1,168 @@
+package gocask
+
+import (
+	"iter"
+
+	"github.com/sebapastore/gocask/internal/bitcask"
+)
+
+// Iterator walks keys in lexicographic order, as they were when it was
+// created. Unlike the sequences of DB.All, Scan and Prefix, it reports why it
+// stopped. It must be closed once done with, which Next does when it runs
+// out of keys and leaving a range loop over All or Keys does too:
+//
+//	it := db.PrefixIterator("user:")
+//	for key, value := range it.All() {
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	it *bitcask.Iterator
+}
+
+// All returns a sequence of every key and its value, in lexicographic order.
+// Each range loop sees the keys as they were when it started and may stop
+// early. A value that cannot be read ends the loop; use Iterator to find out
+// why.
+//
+//	for key, value := range db.All() {
+//		...
+//	}
+func (db *DB) All() iter.Seq2[[]byte, []byte] {
+	return db.db.All()
+}
+
+// Keys returns a sequence of every key, without reading any value.
+func (db *DB) Keys() iter.Seq[[]byte] {
+	return db.db.Keys()
+}
+
+// Scan returns a sequence of the keys from start up to, but not including,
+// end, and their values. An empty end scans to the last key.
+func (db *DB) Scan(start string, end string) iter.Seq2[[]byte, []byte] {
+	return db.db.Scan(start, end)
+}
+
+// Prefix returns a sequence of the keys that start with prefix and their
+// values.
+func (db *DB) Prefix(prefix string) iter.Seq2[[]byte, []byte] {
+	return db.db.Prefix(prefix)
+}
+
+// Iterator returns an iterator over the keys from start up to, but not
+// including, end. An empty end iterates to the last key.
+func (db *DB) Iterator(start string, end string) *Iterator {
+	return &Iterator{it: db.db.Iterator(start, end)}
+}
+
+// PrefixIterator returns an iterator over the keys that start with prefix.
+func (db *DB) PrefixIterator(prefix string) *Iterator {
+	return &Iterator{it: db.db.PrefixIterator(prefix)}
+}
+
+// Next moves to the next key and reports whether there is one.
+func (it *Iterator) Next() bool {
+	return it.it.Next()
+}
+
+// Key returns the current key.
+func (it *Iterator) Key() string {
+	return it.it.Key()
+}
+
+// Value reads the value of the current key.
+func (it *Iterator) Value() ([]byte, error) {
+	return it.it.Value()
+}
+
+// All returns a sequence of the remaining keys and their values. A failed
+// read ends the sequence and is reported by Err.
+func (it *Iterator) All() iter.Seq2[string, []byte] {
+	return it.it.All()
+}
+
+// Keys returns a sequence of the remaining keys without reading any value.
+func (it *Iterator) Keys() iter.Seq[string] {
+	return it.it.Keys()
+}
+
+// Err returns the error that kept the iterator from starting or that ended a
+// range loop over All, if any.
+func (it *Iterator) Err() error {
+	return it.it.Err()
+}
+
+// Close releases the iterator. It is safe to call more than once.
+func (it *Iterator) Close() {
+	it.it.Close()
+}
+
+// Snapshot is a read-only view of the database as it was when it was taken.
+// It must be released once done with, so merges can reclaim the files it
+// holds on to.
+type Snapshot struct {
+	s *bitcask.Snapshot
+}
+
+// Snapshot takes a snapshot of the current state of the database.
+func (db *DB) Snapshot() (*Snapshot, error) {
+	s, err := db.db.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{s: s}, nil
+}
+
+// Get returns the value key had when the snapshot was taken.
+func (s *Snapshot) Get(key string) (string, bool, error) {
+	return s.s.Get(key)
+}
+
+// GetBytes returns the value key had when the snapshot was taken.
+func (s *Snapshot) GetBytes(key []byte) ([]byte, bool, error) {
+	return s.s.GetBytes(key)
+}
+
+// All returns a sequence of every key in the snapshot and its value, see
+// DB.All.
+func (s *Snapshot) All() iter.Seq2[[]byte, []byte] {
+	return s.s.All()
+}
+
+// Keys returns a sequence of every key in the snapshot, without reading any
+// value.
+func (s *Snapshot) Keys() iter.Seq[[]byte] {
+	return s.s.Keys()
+}
+
+// Scan returns a sequence of the keys in the snapshot from start up to, but
+// not including, end, and their values.
+func (s *Snapshot) Scan(start string, end string) iter.Seq2[[]byte, []byte] {
+	return s.s.Scan(start, end)
+}
+
+// Prefix returns a sequence of the keys in the snapshot that start with
+// prefix and their values.
+func (s *Snapshot) Prefix(prefix string) iter.Seq2[[]byte, []byte] {
+	return s.s.Prefix(prefix)
+}
+
+// Iterator returns an iterator over the keys in the snapshot from start up
+// to, but not including, end.
+func (s *Snapshot) Iterator(start string, end string) *Iterator {
+	return &Iterator{it: s.s.Iterator(start, end)}
+}
+
+// PrefixIterator returns an iterator over the keys in the snapshot that
+// start with prefix.
+func (s *Snapshot) PrefixIterator(prefix string) *Iterator {
+	return &Iterator{it: s.s.PrefixIterator(prefix)}
+}
+
+// Release releases the snapshot. It is safe to call more than once.
+func (s *Snapshot) Release() {
+	s.s.Release()
+}
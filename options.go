@@ -0,0 +1,282 @@
+package gocask
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/sebapastore/gocask/internal/bitcask"
+)
+
+// SyncPolicy decides when writes are flushed to stable storage.
+type SyncPolicy = bitcask.SyncPolicy
+
+const (
+	// SyncNever leaves flushing to the operating system.
+	SyncNever = bitcask.SyncNever
+
+	// SyncAlways syncs before every write returns.
+	SyncAlways = bitcask.SyncAlways
+
+	// SyncInterval syncs in the background every Options.SyncInterval, so a
+	// crash loses at most one interval of writes.
+	SyncInterval = bitcask.SyncInterval
+)
+
+// CorruptionPolicy decides what Open does with a corrupted record.
+type CorruptionPolicy = bitcask.CorruptionPolicy
+
+const (
+	// CorruptionSkip skips the bad record and resumes at the next valid one.
+	CorruptionSkip = bitcask.CorruptionSkip
+
+	// CorruptionFail makes Open return a *CorruptionError.
+	CorruptionFail = bitcask.CorruptionFail
+
+	// CorruptionQuarantine moves the whole data file into the corrupt/
+	// subdirectory and opens the database without it.
+	CorruptionQuarantine = bitcask.CorruptionQuarantine
+)
+
+// MaxKeyLength and MaxValueLength are the largest key and value a record can
+// hold, in bytes.
+const (
+	MaxKeyLength   = bitcask.MaxKeyLength
+	MaxValueLength = bitcask.MaxValueLength
+)
+
+// Metrics receives counters and timings from the database. It is called on
+// hot paths, so implementations must be cheap and safe for concurrent use.
+type Metrics = bitcask.Metrics
+
+// Names of the metrics reported to Options.Metrics.
+const (
+	MetricWrites        = bitcask.MetricWrites        // records appended
+	MetricBytesWritten  = bitcask.MetricBytesWritten  // bytes appended to data and blob files
+	MetricReads         = bitcask.MetricReads         // values read by gets and iterators
+	MetricSyncs         = bitcask.MetricSyncs         // syncs of the active file
+	MetricMerges        = bitcask.MetricMerges        // completed merges
+	MetricMergeDuration = bitcask.MetricMergeDuration // time a merge took
+	MetricExpired       = bitcask.MetricExpired       // expired keys evicted
+)
+
+// Options configure a database. The zero value of every field is a usable
+// default, and the durations, sizes and modes fall back to their defaults
+// when zero. Open validates them with Validate.
+type Options struct {
+	// MaxFileSize is how many bytes of records a data file holds before
+	// writes move on to a new one. Defaults to 100 MB.
+	MaxFileSize uint64
+
+	// MaxKeySize and MaxValueSize are the largest key and value writes
+	// accept, in bytes. Larger ones fail with ErrKeyTooLarge and
+	// ErrValueTooLarge before anything is written. MaxKeySize defaults to
+	// the most a record can hold, MaxKeyLength, and values have no limit by
+	// default. A single record must also fit in MaxFileSize, with its value
+	// moved to a blob file if need be.
+	MaxKeySize   uint64
+	MaxValueSize uint64
+
+	// BlobThreshold is the size in bytes above which values are stored in
+	// blob files of their own, with only a reference in the data file. It
+	// can be at most MaxValueLength and defaults to 1 MB. Values whose record
+	// would not fit in MaxFileSize go to blob files whatever their size.
+	BlobThreshold uint64
+
+	// ReadOnly opens the database without writing to its directory. Several
+	// processes can open a database read-only at once.
+	ReadOnly bool
+
+	// SyncPolicy decides when writes are synced. Defaults to SyncNever.
+	SyncPolicy SyncPolicy
+
+	// SyncInterval is how often SyncInterval syncs. Defaults to one second.
+	SyncInterval time.Duration
+
+	// GroupCommit appends concurrent writes together and covers them with
+	// one sync, whatever the SyncPolicy.
+	GroupCommit bool
+
+	// CorruptionPolicy decides how Open handles corrupted records. Defaults
+	// to CorruptionSkip.
+	CorruptionPolicy CorruptionPolicy
+
+	// SweepInterval is how often expired keys are evicted from memory.
+	// Defaults to one minute.
+	SweepInterval time.Duration
+
+	// HistoryVersions and HistoryAge choose which older versions of each key
+	// Merge keeps for History and GetAt: the last HistoryVersions versions
+	// replaced, and every version replaced less than HistoryAge ago. By
+	// default Merge only keeps current values.
+	HistoryVersions int
+	HistoryAge      time.Duration
+
+	// FileMode is the permissions of the files the database creates. Its
+	// directories get search access wherever FileMode grants read access.
+	// Defaults to 0644.
+	FileMode os.FileMode
+
+	// MergeInterval turns on automatic merges: every MergeInterval the
+	// database merges if at least MergeMinFiles data files became immutable
+	// since the last merge. MergeMinFiles defaults to 2.
+	MergeInterval time.Duration
+	MergeMinFiles int
+
+	// Logger receives warnings about problems the database works around, like
+	// skipped corrupted records. Defaults to slog.Default().
+	Logger *slog.Logger
+
+	// Metrics receives counters and timings. By default they are dropped.
+	Metrics Metrics
+}
+
+// DefaultOptions returns the options Open uses when given none.
+func DefaultOptions() Options {
+	return Options{}
+}
+
+// Validate reports the first setting that is out of range, wrapped in
+// ErrInvalidOptions.
+func (o Options) Validate() error {
+	switch {
+	case o.MaxKeySize > MaxKeyLength:
+		return invalidOptions("MaxKeySize %d exceeds the maximum of %d", o.MaxKeySize, MaxKeyLength)
+	case o.BlobThreshold > MaxValueLength:
+		return invalidOptions("BlobThreshold %d exceeds the maximum of %d", o.BlobThreshold, uint64(MaxValueLength))
+	case o.SyncPolicy < SyncNever || o.SyncPolicy > SyncInterval:
+		return invalidOptions("unknown SyncPolicy %d", o.SyncPolicy)
+	case o.SyncInterval < 0:
+		return invalidOptions("SyncInterval %v is negative", o.SyncInterval)
+	case o.CorruptionPolicy < CorruptionSkip || o.CorruptionPolicy > CorruptionQuarantine:
+		return invalidOptions("unknown CorruptionPolicy %d", o.CorruptionPolicy)
+	case o.SweepInterval < 0:
+		return invalidOptions("SweepInterval %v is negative", o.SweepInterval)
+	case o.HistoryVersions < 0:
+		return invalidOptions("HistoryVersions %d is negative", o.HistoryVersions)
+	case o.HistoryAge < 0:
+		return invalidOptions("HistoryAge %v is negative", o.HistoryAge)
+	case o.FileMode&^os.ModePerm != 0:
+		return invalidOptions("FileMode %v has bits other than permissions", o.FileMode)
+	case o.FileMode != 0 && o.FileMode&0600 != 0600:
+		return invalidOptions("FileMode %v does not let the owner read and write", o.FileMode)
+	case o.MergeInterval < 0:
+		return invalidOptions("MergeInterval %v is negative", o.MergeInterval)
+	case o.MergeMinFiles < 0:
+		return invalidOptions("MergeMinFiles %d is negative", o.MergeMinFiles)
+	case o.ReadOnly && o.MergeInterval > 0:
+		return invalidOptions("MergeInterval is set on a read-only database")
+	}
+
+	return nil
+}
+
+func invalidOptions(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrInvalidOptions}, args...)...)
+}
+
+// Option changes one setting of the Options Open uses.
+type Option func(*Options)
+
+// WithOptions replaces every setting with o.
+func WithOptions(o Options) Option {
+	return func(opts *Options) {
+		*opts = o
+	}
+}
+
+// WithMaxFileSize sets Options.MaxFileSize.
+func WithMaxFileSize(size uint64) Option {
+	return func(o *Options) {
+		o.MaxFileSize = size
+	}
+}
+
+// WithSizeLimits sets Options.MaxKeySize and Options.MaxValueSize.
+func WithSizeLimits(maxKeySize uint64, maxValueSize uint64) Option {
+	return func(o *Options) {
+		o.MaxKeySize = maxKeySize
+		o.MaxValueSize = maxValueSize
+	}
+}
+
+// WithBlobThreshold sets Options.BlobThreshold.
+func WithBlobThreshold(threshold uint64) Option {
+	return func(o *Options) {
+		o.BlobThreshold = threshold
+	}
+}
+
+// WithReadOnly opens the database read-only.
+func WithReadOnly() Option {
+	return func(o *Options) {
+		o.ReadOnly = true
+	}
+}
+
+// WithSyncPolicy sets Options.SyncPolicy and Options.SyncInterval.
+func WithSyncPolicy(policy SyncPolicy, interval time.Duration) Option {
+	return func(o *Options) {
+		o.SyncPolicy = policy
+		o.SyncInterval = interval
+	}
+}
+
+// WithGroupCommit turns on group commit.
+func WithGroupCommit() Option {
+	return func(o *Options) {
+		o.GroupCommit = true
+	}
+}
+
+// WithCorruptionPolicy sets Options.CorruptionPolicy.
+func WithCorruptionPolicy(policy CorruptionPolicy) Option {
+	return func(o *Options) {
+		o.CorruptionPolicy = policy
+	}
+}
+
+// WithSweepInterval sets Options.SweepInterval.
+func WithSweepInterval(interval time.Duration) Option {
+	return func(o *Options) {
+		o.SweepInterval = interval
+	}
+}
+
+// WithHistoryRetention sets Options.HistoryVersions and Options.HistoryAge.
+func WithHistoryRetention(versions int, age time.Duration) Option {
+	return func(o *Options) {
+		o.HistoryVersions = versions
+		o.HistoryAge = age
+	}
+}
+
+// WithFileMode sets Options.FileMode.
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *Options) {
+		o.FileMode = mode
+	}
+}
+
+// WithMergeTrigger sets Options.MergeInterval and Options.MergeMinFiles.
+func WithMergeTrigger(interval time.Duration, minFiles int) Option {
+	return func(o *Options) {
+		o.MergeInterval = interval
+		o.MergeMinFiles = minFiles
+	}
+}
+
+// WithLogger sets Options.Logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
+// WithMetrics sets Options.Metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(o *Options) {
+		o.Metrics = metrics
+	}
+}
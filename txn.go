@@ -0,0 +1,77 @@
+package gocask
+
+import "github.com/sebapastore/gocask/internal/bitcask"
+
+// Version is one value a key held, as returned by History.
+type Version = bitcask.Version
+
+// Batch collects writes for DB.Write. The zero value is an empty batch.
+type Batch struct {
+	b bitcask.Batch
+}
+
+// Put adds a write of value under key. Both slices must not be modified
+// until the batch is written.
+func (b *Batch) Put(key []byte, value []byte) {
+	b.b.Put(key, value)
+}
+
+// Delete adds a deletion of key.
+func (b *Batch) Delete(key []byte) {
+	b.b.Delete(key)
+}
+
+// Len returns the number of writes in the batch.
+func (b *Batch) Len() int {
+	return b.b.Len()
+}
+
+// Txn is a transaction started by View or Update. It reads the database as it
+// was when the transaction began, plus its own writes. A Txn must not be used
+// after the function it was passed to returns.
+type Txn struct {
+	tx *bitcask.Txn
+}
+
+// View runs fn in a read-only transaction.
+func (db *DB) View(fn func(tx *Txn) error) error {
+	return db.db.View(func(tx *bitcask.Txn) error {
+		return fn(&Txn{tx: tx})
+	})
+}
+
+// Update runs fn in a read-write transaction and commits its writes
+// atomically when fn returns nil. The commit fails with ErrConflict, writing
+// nothing, if another writer changed a key the transaction read.
+func (db *DB) Update(fn func(tx *Txn) error) error {
+	return db.db.Update(func(tx *bitcask.Txn) error {
+		return fn(&Txn{tx: tx})
+	})
+}
+
+// Get returns the value of key as the transaction sees it.
+func (tx *Txn) Get(key string) (string, bool, error) {
+	return tx.tx.Get(key)
+}
+
+// GetBytes returns the value of key as the transaction sees it.
+func (tx *Txn) GetBytes(key []byte) ([]byte, bool, error) {
+	return tx.tx.GetBytes(key)
+}
+
+// Set buffers a write of value under key until the transaction commits.
+func (tx *Txn) Set(key string, value string) error {
+	return tx.tx.Set(key, value)
+}
+
+// Put buffers a write of value under key until the transaction commits. Both
+// slices must not be modified until then.
+func (tx *Txn) Put(key []byte, value []byte) error {
+	return tx.tx.Put(key, value)
+}
+
+// Delete buffers a deletion of key until the transaction commits. The key
+// must not be modified until then.
+func (tx *Txn) Delete(key []byte) error {
+	return tx.tx.Delete(key)
+}